@@ -7,55 +7,57 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/srelab/watcher/pkg/handlers/harbor"
-
 	"github.com/labstack/echo"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/srelab/common/log"
+	"github.com/srelab/common/slice"
 	"github.com/srelab/watcher/pkg/controller"
+	"github.com/srelab/watcher/pkg/election"
 	"github.com/srelab/watcher/pkg/g"
 	"github.com/srelab/watcher/pkg/handlers"
-	"github.com/srelab/watcher/pkg/handlers/core"
 	"github.com/srelab/watcher/pkg/handlers/etcd"
-	"github.com/srelab/watcher/pkg/handlers/gateway"
-	"github.com/srelab/watcher/pkg/handlers/k8s"
-	"github.com/srelab/watcher/pkg/handlers/sa"
 	"github.com/srelab/watcher/pkg/handlers/shared"
-
-	appsV1 "k8s.io/api/apps/v1"
-	batchV1 "k8s.io/api/batch/v1"
-	apiV1 "k8s.io/api/core/v1"
-	extV1Beta1 "k8s.io/api/extensions/v1beta1"
-	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-
-	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/watch"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/cache"
-	"k8s.io/client-go/tools/clientcmd"
+	"github.com/srelab/watcher/pkg/handlers/sink"
+	"github.com/srelab/watcher/pkg/health"
+	"github.com/srelab/watcher/pkg/kube"
+	"go.etcd.io/etcd/clientv3"
+
+	// the handlers register themselves to shared.Register
+	_ "github.com/srelab/watcher/pkg/handlers/core"
+	_ "github.com/srelab/watcher/pkg/handlers/dlq"
+	_ "github.com/srelab/watcher/pkg/handlers/events"
+	_ "github.com/srelab/watcher/pkg/handlers/exec"
+	_ "github.com/srelab/watcher/pkg/handlers/gateway"
+	_ "github.com/srelab/watcher/pkg/handlers/harbor"
+	_ "github.com/srelab/watcher/pkg/handlers/k8s"
+	_ "github.com/srelab/watcher/pkg/handlers/rules"
+	_ "github.com/srelab/watcher/pkg/handlers/sa"
 )
 
 func Start() {
-	var kubeClient kubernetes.Interface
+	clusters, err := kube.NewClusters(g.Config().Kubernetes)
+	if err != nil {
+		log.Fatalf("can not create kubernetes clients: %v", err)
+	}
 
-	_, err := rest.InClusterConfig()
+	// The enabled handlers, each one after the handlers it depends on
+	informerHandlers, err := shared.NewHandlers(g.Config().Handlers.Enabled)
 	if err != nil {
-		kubeClient = GetClientOutOfCluster()
-	} else {
-		kubeClient = GetClient()
+		log.Fatalf("can not create handlers: %v", err)
 	}
+	informerHandlers = append(informerHandlers, sink.Handlers(g.Config().Handlers.SinkConfigs)...)
 
-	informerHandlers := shared.Handlers{
-		new(k8s.Handler),
-		new(gateway.Handler),
-		new(etcd.Handler),
-		new(harbor.Handler),
-		new(sa.Handler),
-		new(core.Handler),
+	// Every handler processes the events in its own queue,
+	// the dlq handler keeps the events given up by the others
+	dispatcher, err := controller.NewDispatcher(informerHandlers, g.Config().Handlers)
+	if err != nil {
+		log.Fatalf("can not create dispatcher: %v", err)
 	}
 
+	// The running controllers, the handlers list the cached objects through it
+	registry := controller.NewRegistry(clusters.Names())
+
 	// Open the built-in handler interface as http
 	engine := handlers.NewHandlersEngine()
 	engine.Use(handlers.NewMetric())
@@ -64,336 +66,250 @@ func Start() {
 	// Selectively add routes when some handler need to expose the interface
 	handlersRoute := engine.Group("/handlers")
 
-	// Initialize all handlers
+	// Initialize all handlers, a handler is given the handlers initialized before it.
+	// The optional handlers depending on a disabled optional handler are disabled with it,
+	// a required handler cannot start without its dependencies
+	initialized := make(shared.Handlers, 0, len(informerHandlers))
 	for _, handler := range informerHandlers {
-		if err := handler.Init(g.Config(), informerHandlers.Objs(kubeClient)...); err != nil {
-			log.Panicf("init handler[%s] error: %s", handler.Name(), err)
+		optional := slice.ContainsString(g.Config().Handlers.Optional, handler.Name())
+
+		if dependency := disabledDependency(handler, initialized); dependency != "" {
+			if !optional {
+				log.Panicf("init handler[%s] error: it depends on the disabled handler[%s]", handler.Name(), dependency)
+			}
+
+			log.Errorf("optional handler[%s] is disabled, it depends on the disabled handler[%s]", handler.Name(), dependency)
+			dispatcher.Remove(handler.Name())
+			continue
 		}
 
+		if err := handler.Init(g.Config(), initialized.Objs(clusters, dispatcher, registry)...); err != nil {
+			if !optional {
+				log.Panicf("init handler[%s] error: %s", handler.Name(), err)
+			}
+
+			log.Errorf("init optional handler[%s] error, the handler is disabled: %s", handler.Name(), err)
+			dispatcher.Remove(handler.Name())
+			continue
+		}
+
+		initialized = append(initialized, handler)
 		handler.AddRoutes(handlersRoute.Group(handler.RoutePrefix()))
 	}
+	informerHandlers = initialized
 
-	// release resources, as needed
+	// release resources, as needed, the dependencies are closed last
 	defer func() {
-		for _, handler := range informerHandlers {
-			handler.Close()
+		for i := len(informerHandlers) - 1; i >= 0; i-- {
+			informerHandlers[i].Close()
 		}
 	}()
 
-	// starts an HTTP server.
-	go engine.Start(g.Config().Http.GetListenAddr())
-
-	if g.Config().Resource.Pod {
-		informer := cache.NewSharedIndexInformer(
-			&cache.ListWatch{
-				ListFunc: func(options metaV1.ListOptions) (runtime.Object, error) {
-					return kubeClient.CoreV1().Pods(g.Config().Kubernetes.Namespace).List(options)
-				},
-				WatchFunc: func(options metaV1.ListOptions) (watch.Interface, error) {
-					return kubeClient.CoreV1().Pods(g.Config().Kubernetes.Namespace).Watch(options)
-				},
-			},
-			&apiV1.Pod{},
-			0, //Skip resync
-			cache.Indexers{},
-		)
-
-		stopCh := make(chan struct{})
-		defer close(stopCh)
-
-		c := controller.New(kubeClient, informer, shared.ResourceTypePod, informerHandlers)
-		go c.Run(stopCh)
+	// The etcd backend of the leader election shares the client of the etcd handler
+	var etcdClient *clientv3.Client
+	for _, handler := range informerHandlers {
+		if object, ok := handler.(*etcd.Handler); ok {
+			etcdClient = object.Client()
+		}
 	}
 
-	if g.Config().Resource.DaemonSet {
-		informer := cache.NewSharedIndexInformer(
-			&cache.ListWatch{
-				ListFunc: func(options metaV1.ListOptions) (runtime.Object, error) {
-					return kubeClient.ExtensionsV1beta1().DaemonSets(g.Config().Kubernetes.Namespace).List(options)
-				},
-				WatchFunc: func(options metaV1.ListOptions) (watch.Interface, error) {
-					return kubeClient.ExtensionsV1beta1().DaemonSets(g.Config().Kubernetes.Namespace).Watch(options)
-				},
-			},
-			&extV1Beta1.DaemonSet{},
-			0, //Skip resync
-			cache.Indexers{},
-		)
-
-		stopCh := make(chan struct{})
-		defer close(stopCh)
-
-		c := controller.New(kubeClient, informer, shared.ResourceTypeDaemonSet, informerHandlers)
-		go c.Run(stopCh)
+	// The Lease object of the leader election lives in the default cluster
+	elector, err := election.New(g.Config().LeaderElection, clusters[0].Client, etcdClient)
+	if err != nil {
+		log.Panicf("init leader election error: %s", err)
 	}
 
-	if g.Config().Resource.ReplicaSet {
-		informer := cache.NewSharedIndexInformer(
-			&cache.ListWatch{
-				ListFunc: func(options metaV1.ListOptions) (runtime.Object, error) {
-					return kubeClient.ExtensionsV1beta1().ReplicaSets(g.Config().Kubernetes.Namespace).List(options)
-				},
-				WatchFunc: func(options metaV1.ListOptions) (watch.Interface, error) {
-					return kubeClient.ExtensionsV1beta1().ReplicaSets(g.Config().Kubernetes.Namespace).Watch(options)
-				},
-			},
-			&extV1Beta1.ReplicaSet{},
-			0, //Skip resync
-			cache.Indexers{},
-		)
-
-		stopCh := make(chan struct{})
-		defer close(stopCh)
-
-		c := controller.New(kubeClient, informer, shared.ResourceTypeReplicaSet, informerHandlers)
-		go c.Run(stopCh)
-	}
+	engine.GET("/leader", elector.GetState)
 
-	if g.Config().Resource.Services {
-		informer := cache.NewSharedIndexInformer(
-			&cache.ListWatch{
-				ListFunc: func(options metaV1.ListOptions) (runtime.Object, error) {
-					return kubeClient.CoreV1().Services(g.Config().Kubernetes.Namespace).List(options)
-				},
-				WatchFunc: func(options metaV1.ListOptions) (watch.Interface, error) {
-					return kubeClient.CoreV1().Services(g.Config().Kubernetes.Namespace).Watch(options)
-				},
-			},
-			&apiV1.Service{},
-			0, //Skip resync
-			cache.Indexers{},
-		)
-
-		stopCh := make(chan struct{})
-		defer close(stopCh)
-
-		c := controller.New(kubeClient, informer, shared.ResourceTypeService, informerHandlers)
-		go c.Run(stopCh)
+	// The replica is ready once the informers have synced and the backends of the handlers are reachable
+	checks := health.New()
+	checks.Add("controllers", registry)
+	for _, handler := range informerHandlers {
+		if checker, ok := handler.(shared.HealthChecker); ok {
+			checks.Add(handler.Name(), checker)
+		}
 	}
 
-	if g.Config().Resource.Deployment {
-		informer := cache.NewSharedIndexInformer(
-			&cache.ListWatch{
-				ListFunc: func(options metaV1.ListOptions) (runtime.Object, error) {
-					return kubeClient.AppsV1().Deployments(g.Config().Kubernetes.Namespace).List(options)
-				},
-				WatchFunc: func(options metaV1.ListOptions) (watch.Interface, error) {
-					return kubeClient.AppsV1().Deployments(g.Config().Kubernetes.Namespace).Watch(options)
-				},
-			},
-			&appsV1.Deployment{},
-			0, //Skip resync
-			cache.Indexers{},
-		)
-
-		stopCh := make(chan struct{})
-		defer close(stopCh)
-
-		c := controller.New(kubeClient, informer, shared.ResourceTypeDeployment, informerHandlers)
-		go c.Run(stopCh)
-	}
+	engine.GET("/healthz", checks.GetLiveness)
+	engine.GET("/readyz", checks.GetReadiness)
 
-	if g.Config().Resource.Namespace {
-		informer := cache.NewSharedIndexInformer(
-			&cache.ListWatch{
-				ListFunc: func(options metaV1.ListOptions) (runtime.Object, error) {
-					return kubeClient.CoreV1().Namespaces().List(options)
-				},
-				WatchFunc: func(options metaV1.ListOptions) (watch.Interface, error) {
-					return kubeClient.CoreV1().Namespaces().Watch(options)
-				},
-			},
-			&apiV1.Namespace{},
-			0, //Skip resync
-			cache.Indexers{},
-		)
-
-		stopCh := make(chan struct{})
-		defer close(stopCh)
-
-		c := controller.New(kubeClient, informer, shared.ResourceTypeNamespace, informerHandlers)
-		go c.Run(stopCh)
-	}
+	// starts an HTTP server.
+	go engine.Start(g.Config().Http.GetListenAddr())
 
-	if g.Config().Resource.ReplicationController {
-		informer := cache.NewSharedIndexInformer(
-			&cache.ListWatch{
-				ListFunc: func(options metaV1.ListOptions) (runtime.Object, error) {
-					return kubeClient.CoreV1().ReplicationControllers(g.Config().Kubernetes.Namespace).List(options)
-				},
-				WatchFunc: func(options metaV1.ListOptions) (watch.Interface, error) {
-					return kubeClient.CoreV1().ReplicationControllers(g.Config().Kubernetes.Namespace).Watch(options)
-				},
-			},
-			&apiV1.ReplicationController{},
-			0, //Skip resync
-			cache.Indexers{},
-		)
-
-		stopCh := make(chan struct{})
-		defer close(stopCh)
-
-		c := controller.New(kubeClient, informer, shared.ResourceTypeReplicationController, informerHandlers)
-		go c.Run(stopCh)
-	}
+	electionCtx, stopElection := context.WithCancel(context.Background())
+	defer stopElection()
 
-	if g.Config().Resource.Job {
-		informer := cache.NewSharedIndexInformer(
-			&cache.ListWatch{
-				ListFunc: func(options metaV1.ListOptions) (runtime.Object, error) {
-					return kubeClient.BatchV1().Jobs(g.Config().Kubernetes.Namespace).List(options)
-				},
-				WatchFunc: func(options metaV1.ListOptions) (watch.Interface, error) {
-					return kubeClient.BatchV1().Jobs(g.Config().Kubernetes.Namespace).Watch(options)
-				},
-			},
-			&batchV1.Job{},
-			0, //Skip resync
-			cache.Indexers{},
-		)
-
-		stopCh := make(chan struct{})
-		defer close(stopCh)
-
-		c := controller.New(kubeClient, informer, shared.ResourceTypeJob, informerHandlers)
-		go c.Run(stopCh)
-	}
+	dispatcherStop := make(chan struct{})
+	defer close(dispatcherStop)
 
-	if g.Config().Resource.PersistentVolume {
-		informer := cache.NewSharedIndexInformer(
-			&cache.ListWatch{
-				ListFunc: func(options metaV1.ListOptions) (runtime.Object, error) {
-					return kubeClient.CoreV1().PersistentVolumes().List(options)
-				},
-				WatchFunc: func(options metaV1.ListOptions) (watch.Interface, error) {
-					return kubeClient.CoreV1().PersistentVolumes().Watch(options)
-				},
-			},
-			&apiV1.PersistentVolume{},
-			0, //Skip resync
-			cache.Indexers{},
-		)
-
-		stopCh := make(chan struct{})
-		defer close(stopCh)
-
-		c := controller.New(kubeClient, informer, shared.ResourceTypePersistentVolume, informerHandlers)
-		go c.Run(stopCh)
-	}
+	go dispatcher.Run(dispatcherStop)
 
-	if g.Config().Resource.Secret {
-		informer := cache.NewSharedIndexInformer(
-			&cache.ListWatch{
-				ListFunc: func(options metaV1.ListOptions) (runtime.Object, error) {
-					return kubeClient.CoreV1().Secrets(g.Config().Kubernetes.Namespace).List(options)
-				},
-				WatchFunc: func(options metaV1.ListOptions) (watch.Interface, error) {
-					return kubeClient.CoreV1().Secrets(g.Config().Kubernetes.Namespace).Watch(options)
-				},
-			},
-			&apiV1.Secret{},
-			0, //Skip resync
-			cache.Indexers{},
-		)
-
-		stopCh := make(chan struct{})
-		defer close(stopCh)
-
-		c := controller.New(kubeClient, informer, shared.ResourceTypeSecret, informerHandlers)
-		go c.Run(stopCh)
-	}
+	// Only the leader runs the informers, they are stopped as soon as the leadership is lost
+	controllers := newControllerSet(clusters, dispatcher, registry)
+	go elector.Run(electionCtx, func(stopCh <-chan struct{}) {
+		controllers.Run(stopCh)
+		go reconcile(informerHandlers, registry, stopCh)
+	})
 
-	if g.Config().Resource.ConfigMap {
-		informer := cache.NewSharedIndexInformer(
-			&cache.ListWatch{
-				ListFunc: func(options metaV1.ListOptions) (runtime.Object, error) {
-					return kubeClient.CoreV1().ConfigMaps(g.Config().Kubernetes.Namespace).List(options)
-				},
-				WatchFunc: func(options metaV1.ListOptions) (watch.Interface, error) {
-					return kubeClient.CoreV1().ConfigMaps(g.Config().Kubernetes.Namespace).Watch(options)
-				},
-			},
-			&apiV1.ConfigMap{},
-			0, //Skip resync
-			cache.Indexers{},
-		)
-
-		stopCh := make(chan struct{})
-		defer close(stopCh)
-
-		c := controller.New(kubeClient, informer, shared.ResourceTypeConfigMap, informerHandlers)
-		go c.Run(stopCh)
+	// The config is reloaded when the file is written or on SIGHUP, one reload at a time
+	reloads := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case reloads <- struct{}{}:
+		default:
+		}
 	}
 
-	if g.Config().Resource.Ingress {
-		informer := cache.NewSharedIndexInformer(
-			&cache.ListWatch{
-				ListFunc: func(options metaV1.ListOptions) (runtime.Object, error) {
-					return kubeClient.ExtensionsV1beta1().Ingresses(g.Config().Kubernetes.Namespace).List(options)
-				},
-				WatchFunc: func(options metaV1.ListOptions) (watch.Interface, error) {
-					return kubeClient.ExtensionsV1beta1().Ingresses(g.Config().Kubernetes.Namespace).Watch(options)
-				},
-			},
-			&extV1Beta1.Ingress{},
-			0, //Skip resync
-			cache.Indexers{},
-		)
-
-		stopCh := make(chan struct{})
-		defer close(stopCh)
-
-		c := controller.New(kubeClient, informer, shared.ResourceTypeIngress, informerHandlers)
-		go c.Run(stopCh)
-	}
+	g.WatchConfig(notify)
+	go func() {
+		for range reloads {
+			reload(informerHandlers, dispatcher, controllers)
+		}
+	}()
+
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go func() {
+		for range sighup {
+			notify()
+		}
+	}()
 
 	sigterm := make(chan os.Signal, 1)
 	signal.Notify(sigterm, syscall.SIGTERM)
 	signal.Notify(sigterm, syscall.SIGINT)
 	<-sigterm
 
-	// Wait for signal to gracefully shutdown the server with a timeout of 10 seconds.
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// The queued events are processed within ShutdownTimeout, the handlers are closed by the defer above
+	ctx, cancel := context.WithTimeout(context.Background(), g.Config().ShutdownTimeout*time.Second)
 	defer cancel()
 
+	shutdown(ctx, engine, stopElection, controllers, dispatcher)
+}
+
+// Stops accepting the informer events and the replays from the HTTP server, then waits for the queues
+// to be processed. The events left when ctx is done are kept by the dlq handler until the next start
+func shutdown(ctx context.Context, engine *echo.Echo, stopElection context.CancelFunc,
+	controllers *controllerSet, dispatcher *controller.Dispatcher) {
+	log.Infof("shutting down")
+
+	// the leadership is released, the controllers dispatch the events they have already received
+	stopElection()
+	if !controllers.Wait(ctx) {
+		log.Warnf("controllers have not stopped before the shutdown timeout")
+	}
+
 	// stops the server gracefully.
-	if err := engine.Shutdown(ctx); err != nil {
-		log.Fatal(err)
+	serverCtx, serverCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer serverCancel()
+
+	if err := engine.Shutdown(serverCtx); err != nil {
+		log.Errorf("shutdown server error: %s", err)
 	}
+
+	dispatcher.Shutdown(ctx)
 }
 
-// GetClient returns a k8s clientset to the request from inside of cluster
-func GetClient() kubernetes.Interface {
-	config, err := rest.InClusterConfig()
-	if err != nil {
-		log.Fatalf("can not get watch config: %v", err)
+// Returns the first handler the handler depends on which has not been initialized, empty when there is none
+func disabledDependency(handler shared.Handler, initialized shared.Handlers) string {
+	for _, dependency := range shared.Dependencies(handler.Name()) {
+		enabled := false
+		for _, object := range initialized {
+			if object.Name() == dependency {
+				enabled = true
+				break
+			}
+		}
+
+		if !enabled {
+			return dependency
+		}
 	}
 
-	clientset, err := kubernetes.NewForConfig(config)
+	return ""
+}
+
+// Reads the config file again, an invalid config is rejected as a whole and the running one is kept.
+// The filters of the dispatcher, the handlers implementing shared.Reloader and the watched
+// resources are updated, the other settings (e.g. the clusters, the HTTP server) need a restart
+func reload(handlers shared.Handlers, dispatcher *controller.Dispatcher, controllers *controllerSet) {
+	config, err := g.Load()
 	if err != nil {
-		log.Fatalf("can not create watch client: %v", err)
+		log.Errorf("reload config error, the running config is kept: %s", err)
+		return
+	}
+
+	if err := dispatcher.Reload(config.Handlers); err != nil {
+		log.Errorf("reload config error, the running config is kept: %s", err)
+		return
+	}
+
+	// the handlers reloaded before a failing one are given the running config back,
+	// which they have accepted already, so every component keeps the same config
+	running := g.Config()
+	reloaded := make(shared.Handlers, 0, len(handlers))
+	for _, handler := range handlers {
+		reloader, ok := handler.(shared.Reloader)
+		if !ok {
+			continue
+		}
+
+		if err := reloader.Reload(config); err != nil {
+			log.Errorf("reload handler[%s] error, the running config is kept: %s", handler.Name(), err)
+			rollback(running, reloaded, dispatcher)
+			return
+		}
+
+		reloaded = append(reloaded, handler)
 	}
 
-	return clientset
+	g.SetConfig(config)
+	controllers.Sync(config)
+	log.Infof("config reloaded")
 }
 
-func buildOutOfClusterConfig() (*rest.Config, error) {
-	kubeconfigPath := g.Config().Kubernetes.Config
-	if kubeconfigPath == "" {
-		kubeconfigPath = os.Getenv("HOME") + "/.kube/config"
+// Reloads the running config into the dispatcher and the handlers already reloaded
+func rollback(running *g.Configuration, reloaded shared.Handlers, dispatcher *controller.Dispatcher) {
+	if err := dispatcher.Reload(running.Handlers); err != nil {
+		log.Errorf("restore the running config of the dispatcher error: %s", err)
 	}
 
-	return clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+	for _, handler := range reloaded {
+		if err := handler.(shared.Reloader).Reload(running); err != nil {
+			log.Errorf("restore the running config of handler[%s] error: %s", handler.Name(), err)
+		}
+	}
 }
 
-// GetClientOutOfCluster returns a k8s clientset to the request from outside of cluster
-func GetClientOutOfCluster() kubernetes.Interface {
-	config, err := buildOutOfClusterConfig()
-	if err != nil {
-		log.Fatalf("Can not get kubernetes config: %v", err)
+// The "create" events of the objects existing before the start are skipped by the controllers,
+// once the informers are synced the handlers catch up with the changes missed while not leading
+func reconcile(handlers shared.Handlers, registry *controller.Registry, stopCh <-chan struct{}) {
+	if !registry.WaitForCacheSync(stopCh) {
+		return
 	}
 
-	clientset, err := kubernetes.NewForConfig(config)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	return clientset
+	go func() {
+		select {
+		case <-stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for _, handler := range handlers {
+		reconciler, ok := handler.(shared.Reconciler)
+		if !ok {
+			continue
+		}
+
+		if err := reconciler.Reconcile(ctx, registry); err != nil {
+			log.Errorf("reconcile handler[%s] error: %s", handler.Name(), err)
+			continue
+		}
+
+		log.Infof("handler[%s] reconciled", handler.Name())
+	}
 }
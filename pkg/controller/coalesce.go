@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/srelab/watcher/pkg/g"
+	"github.com/srelab/watcher/pkg/handlers/shared"
+)
+
+// coalescer merges the update events of an object received within the window into the first one,
+// which keeps the OldObject of the first update and takes the Object of the last one
+type coalescer struct {
+	lock sync.Mutex
+
+	// the update waiting for the end of the window, keyed by the object
+	pending map[string]*shared.Event
+
+	// the updates added to the queue after the window, false once flushed by another event
+	delayed map[*shared.Event]bool
+}
+
+func newCoalescer() *coalescer {
+	return &coalescer{
+		pending: make(map[string]*shared.Event),
+		delayed: make(map[*shared.Event]bool),
+	}
+}
+
+// Returns the window of the resource type, 0 when its updates are not coalesced
+func coalesceWindow(resourceType shared.ResourceType) time.Duration {
+	config := g.Config().Kubernetes.Coalesce
+	if config.Window <= 0 {
+		return 0
+	}
+
+	if len(config.Resources) == 0 {
+		return config.Window * time.Second
+	}
+
+	for _, resource := range config.Resources {
+		if strings.EqualFold(resource, string(resourceType)) {
+			return config.Window * time.Second
+		}
+	}
+
+	return 0
+}
+
+// Adds the event to the queue, an update waits for the window to pass and takes the later updates
+// of the object in the meantime. Any other event of the object flushes the pending update first,
+// so the events of an object are still dispatched in order
+func (c *Controller) add(event *shared.Event) {
+	c.coalescer.lock.Lock()
+	defer c.coalescer.lock.Unlock()
+
+	if pending, ok := c.coalescer.pending[event.Key]; ok {
+		if event.Action == "update" {
+			pending.Object = event.Object
+			promeCoalesced.WithLabelValues(c.cluster, string(c.resourceType)).Inc()
+			return
+		}
+
+		// the pending event is still added after the window, it is skipped then
+		delete(c.coalescer.pending, event.Key)
+		c.coalescer.delayed[pending] = false
+
+		flushed := *pending
+		c.queue.Add(&flushed)
+	}
+
+	if window := coalesceWindow(c.resourceType); event.Action == "update" && window > 0 {
+		c.coalescer.pending[event.Key] = event
+		c.coalescer.delayed[event] = true
+		c.queue.AddAfter(event, window)
+		return
+	}
+
+	c.queue.Add(event)
+}
+
+// Adds every pending update to the queue at once, the controller is stopping
+func (c *Controller) flush() {
+	c.coalescer.lock.Lock()
+	defer c.coalescer.lock.Unlock()
+
+	for key, pending := range c.coalescer.pending {
+		delete(c.coalescer.pending, key)
+		c.coalescer.delayed[pending] = false
+
+		flushed := *pending
+		c.queue.Add(&flushed)
+	}
+}
+
+// Returns false when the event has been flushed before the end of its window,
+// a coalesced update does not take any later update from then on
+func (c *Controller) take(event *shared.Event) bool {
+	c.coalescer.lock.Lock()
+	defer c.coalescer.lock.Unlock()
+
+	dispatch, ok := c.coalescer.delayed[event]
+	if !ok {
+		return true
+	}
+
+	delete(c.coalescer.delayed, event)
+	if c.coalescer.pending[event.Key] == event {
+		delete(c.coalescer.pending, event.Key)
+	}
+
+	return dispatch
+}
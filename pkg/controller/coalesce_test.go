@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/srelab/watcher/pkg/g"
+	"github.com/srelab/watcher/pkg/handlers/shared"
+
+	"k8s.io/client-go/util/workqueue"
+)
+
+// fakeQueue records the added events, the delayed ones are returned after the others
+type fakeQueue struct {
+	workqueue.RateLimitingInterface
+
+	added   []*shared.Event
+	delayed []*shared.Event
+}
+
+func (q *fakeQueue) Add(item interface{}) {
+	q.added = append(q.added, item.(*shared.Event))
+}
+
+func (q *fakeQueue) AddAfter(item interface{}, duration time.Duration) {
+	q.delayed = append(q.delayed, item.(*shared.Event))
+}
+
+// Returns the "action old->new" of the events the worker would dispatch
+func (q *fakeQueue) dispatched(c *Controller) []string {
+	var events []string
+	for _, event := range append(q.added, q.delayed...) {
+		if !c.take(event) {
+			continue
+		}
+
+		old, _ := event.OldObject.(string)
+		events = append(events, event.Action+" "+old+"->"+event.Object.(string))
+	}
+
+	return events
+}
+
+func TestCoalesce(t *testing.T) {
+	update := func(old, new string) *shared.Event {
+		return &shared.Event{Key: "default/web", Action: "update", ResourceType: shared.ResourceTypePod, OldObject: old, Object: new}
+	}
+	deletion := &shared.Event{Key: "default/web", Action: "delete", ResourceType: shared.ResourceTypePod, Object: "v3"}
+	other := &shared.Event{Key: "default/api", Action: "update", ResourceType: shared.ResourceTypePod, OldObject: "a1", Object: "a2"}
+
+	tests := []struct {
+		name   string
+		config g.CoalesceConfig
+		events []*shared.Event
+		flush  bool
+		want   []string
+	}{
+		{
+			name:   "disabled",
+			config: g.CoalesceConfig{},
+			events: []*shared.Event{update("v1", "v2"), update("v2", "v3")},
+			want:   []string{"update v1->v2", "update v2->v3"},
+		},
+		{
+			name:   "updates are coalesced",
+			config: g.CoalesceConfig{Window: 5},
+			events: []*shared.Event{update("v1", "v2"), update("v2", "v3"), update("v3", "v4")},
+			want:   []string{"update v1->v4"},
+		},
+		{
+			name:   "objects are coalesced apart",
+			config: g.CoalesceConfig{Window: 5},
+			events: []*shared.Event{update("v1", "v2"), other, update("v2", "v3")},
+			want:   []string{"update v1->v3", "update a1->a2"},
+		},
+		{
+			name:   "resource not selected",
+			config: g.CoalesceConfig{Window: 5, Resources: []string{"deployment"}},
+			events: []*shared.Event{update("v1", "v2"), update("v2", "v3")},
+			want:   []string{"update v1->v2", "update v2->v3"},
+		},
+		{
+			name:   "resource selected case-insensitively",
+			config: g.CoalesceConfig{Window: 5, Resources: []string{"pod"}},
+			events: []*shared.Event{update("v1", "v2"), update("v2", "v3")},
+			want:   []string{"update v1->v3"},
+		},
+		{
+			name:   "delete flushes the pending update",
+			config: g.CoalesceConfig{Window: 5},
+			events: []*shared.Event{update("v1", "v2"), update("v2", "v3"), deletion},
+			want:   []string{"update v1->v3", "delete ->v3"},
+		},
+		{
+			name:   "update after a flush waits again",
+			config: g.CoalesceConfig{Window: 5},
+			events: []*shared.Event{update("v1", "v2"), deletion, update("v3", "v4"), update("v4", "v5")},
+			want:   []string{"update v1->v2", "delete ->v3", "update v3->v5"},
+		},
+		{
+			name:   "stop flushes every pending update",
+			config: g.CoalesceConfig{Window: 5},
+			events: []*shared.Event{update("v1", "v2"), other, update("v2", "v3")},
+			flush:  true,
+			want:   []string{"update v1->v3", "update a1->a2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g.SetConfig(&g.Configuration{Kubernetes: &g.Kubernetes{Coalesce: tt.config}})
+
+			queue := new(fakeQueue)
+			c := &Controller{cluster: "test", resourceType: shared.ResourceTypePod, queue: queue, coalescer: newCoalescer()}
+			for _, event := range tt.events {
+				c.add(event)
+			}
+
+			if tt.flush {
+				c.flush()
+				if len(c.coalescer.pending) != 0 {
+					t.Errorf("pending = %v, want none after the flush", c.coalescer.pending)
+				}
+			}
+
+			got, want := queue.dispatched(c), append([]string(nil), tt.want...)
+			if tt.flush {
+				// the flushed updates are added in the order of the map
+				sort.Strings(got)
+				sort.Strings(want)
+			}
+
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("dispatched = %v, want %v", got, want)
+			}
+		})
+	}
+}
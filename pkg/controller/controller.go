@@ -4,113 +4,264 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/srelab/watcher/pkg/g"
 	"github.com/srelab/watcher/pkg/handlers/shared"
+	"github.com/srelab/watcher/pkg/kube"
 
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 
 	"github.com/srelab/common/log"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/util/workqueue"
 )
 
+// the times an event is retried by a handler
 const maxRetries = 5
 
-var serverStartTime time.Time
-
 // Controller object
 type Controller struct {
-	logger    *log.Logger
-	clientset kubernetes.Interface
-	queue     workqueue.RateLimitingInterface
-	informer  cache.SharedIndexInformer
-	handlers  []shared.Handler
+	logger       *log.Logger
+	cluster      string
+	resourceType shared.ResourceType
+	clientset    kubernetes.Interface
+	queue        workqueue.RateLimitingInterface
+	informers    []cache.SharedIndexInformer
+	dispatcher   *Dispatcher
+
+	// the objects created before the start are not dispatched as "create" events
+	startTime time.Time
+
+	coalescer *coalescer
+	owners    *owners
+	deduper   *deduper
 }
 
-func New(client kubernetes.Interface, informer cache.SharedIndexInformer, resourceType shared.ResourceType, handlers []shared.Handler) *Controller {
-	var event shared.Event
-	var err error
+// The informers of a resource type, e.g. one per watched namespace, feed the same controller
+func New(cluster *kube.Cluster, informers []cache.SharedIndexInformer, resourceType shared.ResourceType, dispatcher *Dispatcher) *Controller {
+	c := &Controller{
+		cluster:      cluster.Name,
+		resourceType: resourceType,
+		clientset:    cluster.Client,
+		informers:    informers,
+		queue:        workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), cluster.Name+"/"+string(resourceType)),
+		dispatcher:   dispatcher,
+		coalescer:    newCoalescer(),
+		owners:       newOwners(cluster),
+		deduper:      newDeduper(),
+	}
 
-	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
-	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+	handler := cache.ResourceEventHandlerFuncs{
 		// 当资源第一次加入到 Informer 的缓存后调用
 		AddFunc: func(object interface{}) {
-			event.Key, err = cache.MetaNamespaceKeyFunc(object)
-			event.Action = "create"
-			event.ResourceType = resourceType
-			event.Namespace = event.GetObjectMetaData().Namespace
-			event.Object = object
-
-			if err == nil {
-				queue.Add(event)
+			key, err := cache.MetaNamespaceKeyFunc(object)
+			if err != nil {
+				c.dropped(object, err)
+				return
 			}
+
+			c.enqueue(&shared.Event{
+				Key:          key,
+				Action:       "create",
+				ResourceType: resourceType,
+				Cluster:      cluster.Name,
+				Object:       Typed(object),
+			})
 		},
 
 		// 当既有资源被修改时调用。oldObj 是资源的上一个状态，newObj 则是新状态
 		// resync 时此方法也被调用，即使对象没有任何变化
 		UpdateFunc: func(oldObject, object interface{}) {
-			event.Key, err = cache.MetaNamespaceKeyFunc(oldObject)
-			event.Action = "update"
-			event.ResourceType = resourceType
-			event.Namespace = event.GetObjectMetaData().Namespace
-			event.Object = object
-			event.OldObject = oldObject
-
-			if err == nil {
-				queue.Add(event)
+			key, err := cache.MetaNamespaceKeyFunc(oldObject)
+			if err != nil {
+				c.dropped(object, err)
+				return
 			}
+
+			c.enqueue(&shared.Event{
+				Key:          key,
+				Action:       "update",
+				ResourceType: resourceType,
+				Cluster:      cluster.Name,
+				Object:       Typed(object),
+				OldObject:    Typed(oldObject),
+			})
 		},
 
 		// 当既有资源被删除时调用，obj是对象的最后状态，如果最后状态未知则返回 DeletedFinalStateUnknown
+		// DeletedFinalStateUnknown 解包后，handler 可以根据最后已知的状态进行清理
 		DeleteFunc: func(object interface{}) {
-			event.Key, err = cache.DeletionHandlingMetaNamespaceKeyFunc(object)
-			event.Action = "delete"
-			event.ResourceType = resourceType
-			event.Namespace = event.GetObjectMetaData().Namespace
-			event.Object = object
-
-			if err == nil {
-				queue.Add(event)
+			key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(object)
+			if err != nil {
+				c.dropped(object, err)
+				return
+			}
+
+			if tombstone, ok := object.(cache.DeletedFinalStateUnknown); ok {
+				promeTombstones.WithLabelValues(cluster.Name, string(resourceType)).Inc()
+				log.Warnf("deletion of %s[%s] in cluster[%s] was missed, using the last known state", resourceType, key, cluster.Name)
+				object = tombstone.Obj
 			}
+
+			c.enqueue(&shared.Event{
+				Key:          key,
+				Action:       "delete",
+				ResourceType: resourceType,
+				Cluster:      cluster.Name,
+				Object:       Typed(object),
+			})
 		},
-	})
+	}
+
+	for _, informer := range informers {
+		informer.AddEventHandler(handler)
+	}
+
+	return c
+}
+
+func (c *Controller) enqueue(event *shared.Event) {
+	accessor, err := meta.Accessor(event.Object)
+	if err != nil {
+		c.dropped(event.Object, fmt.Errorf("%s event of %s: %s", event.Action, event.Key, err))
+		return
+	}
 
-	return &Controller{
-		clientset: client,
-		informer:  informer,
-		queue:     queue,
-		handlers:  handlers,
+	// a cluster-wide informer also delivers the objects of the namespaces not watched
+	event.Namespace = accessor.GetNamespace()
+	if event.Namespace != "" && !g.Config().Kubernetes.Watches(event.Namespace) {
+		return
 	}
+
+	c.add(event)
 }
 
-// Run starts the watch controller
+// The handlers cannot process an event whose metadata cannot be resolved, make the drop visible
+func (c *Controller) dropped(object interface{}, err error) {
+	log.Errorf("%s event of cluster[%s] dropped, the metadata of %T cannot be resolved: %s", c.resourceType, c.cluster, object, err)
+	utilruntime.HandleError(err)
+}
+
+// Typed converts the unstructured objects delivered by the dynamic informers into the typed
+// API structs registered in the client-go scheme, so handlers can keep using e.g. *apiV1.Pod.
+// Custom resources are not registered in the scheme and stay *unstructured.Unstructured
+func Typed(object interface{}) interface{} {
+	u, ok := object.(*unstructured.Unstructured)
+	if !ok {
+		return object
+	}
+
+	typedObject, err := scheme.Scheme.New(u.GroupVersionKind())
+	if err != nil {
+		return object
+	}
+
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), typedObject); err != nil {
+		log.Errorf("convert %s[%s] error: %s", u.GetKind(), u.GetName(), err)
+		return object
+	}
+
+	return typedObject
+}
+
+// Run starts the watch controller and blocks until stopCh is closed and the queued events are dispatched
 func (c *Controller) Run(stopCh <-chan struct{}) {
 	defer utilruntime.HandleCrash()
 	defer c.queue.ShutDown()
 
-	log.Info("starting watch controller")
-	serverStartTime = time.Now().Local()
+	log.Infof("starting watch controller of cluster[%s]", c.cluster)
+	c.startTime = time.Now().Local()
+
+	for _, informer := range c.informers {
+		go informer.Run(stopCh)
+	}
 
-	go c.informer.Run(stopCh)
+	go wait.Until(c.updateInformerMetrics, 10*time.Second, stopCh)
+	defer promeInformerSynced.WithLabelValues(c.cluster, string(c.resourceType)).Set(0)
 
 	if !cache.WaitForCacheSync(stopCh, c.HasSynced) {
 		utilruntime.HandleError(fmt.Errorf("timed out waiting for caches to sync"))
 		return
 	}
 
-	log.Info("watch controller synced and ready")
+	// the events received before stopCh is closed are still dispatched, Run returns once they are
+	go func() {
+		<-stopCh
+		c.flush()
+		c.queue.ShutDown()
+	}()
+
+	log.Infof("watch controller of cluster[%s] synced and ready", c.cluster)
 	wait.Until(c.runWorker, time.Second, stopCh)
 }
 
-// HasSynced is required for the cache.Controller interface.
+// HasSynced returns true when all the informers have synced
 func (c *Controller) HasSynced() bool {
-	return c.informer.HasSynced()
+	for _, informer := range c.informers {
+		if !informer.HasSynced() {
+			return false
+		}
+	}
+
+	return true
 }
 
-// LastSyncResourceVersion is required for the cache.Controller interface.
-func (c *Controller) LastSyncResourceVersion() string {
-	return c.informer.LastSyncResourceVersion()
+// List returns every object of the watched namespaces in the cache of the informers as a "create" event
+func (c *Controller) List() []*shared.Event {
+	events := make([]*shared.Event, 0)
+	for _, object := range c.objects() {
+		key, err := cache.MetaNamespaceKeyFunc(object)
+		if err != nil {
+			continue
+		}
+
+		event := &shared.Event{
+			Key:          key,
+			Action:       "create",
+			ResourceType: c.resourceType,
+			Cluster:      c.cluster,
+			Object:       Typed(object),
+		}
+
+		event.Namespace = event.GetObjectMetaData().Namespace
+		if event.Namespace != "" && !g.Config().Kubernetes.Watches(event.Namespace) {
+			continue
+		}
+
+		events = append(events, event)
+	}
+
+	return events
+}
+
+func (c *Controller) updateInformerMetrics() {
+	var synced, resourceVersion float64
+	if c.HasSynced() {
+		synced = 1
+	}
+
+	for _, informer := range c.informers {
+		if value := resourceVersionValue(informer.LastSyncResourceVersion()); value > resourceVersion {
+			resourceVersion = value
+		}
+	}
+
+	promeInformerSynced.WithLabelValues(c.cluster, string(c.resourceType)).Set(synced)
+	promeInformerResourceVersion.WithLabelValues(c.cluster, string(c.resourceType)).Set(resourceVersion)
+}
+
+func (c *Controller) objects() []interface{} {
+	objects := make([]interface{}, 0)
+	for _, informer := range c.informers {
+		objects = append(objects, informer.GetStore().List()...)
+	}
+
+	return objects
 }
 
 func (c *Controller) runWorker() {
@@ -127,57 +278,46 @@ func (c *Controller) processNextItem() bool {
 	}
 	defer c.queue.Done(item)
 
-	// Convert the item obtained by queue to event
-	event := item.(shared.Event)
-
-	// Give the event to each handler
-	err := c.processItem(&event)
-	if err == nil {
-		// No error, reset the ratelimit counters
-		c.queue.Forget(event)
-	} else if c.queue.NumRequeues(event) < maxRetries {
-		log.Errorf("error processing %s (will retry): %v", event.Key, err)
-		c.queue.AddRateLimited(event)
-	} else {
-		// err != nil and too many retries
-		log.Errorf("error processing %s (giving up): %v", event.Key, err)
-		c.queue.Forget(event)
-		utilruntime.HandleError(err)
+	// Convert the item obtained by queue to event,
+	// the retries are handled by the queue of each handler
+	if event := item.(*shared.Event); c.take(event) {
+		c.processItem(event)
 	}
+	c.queue.Forget(item)
 
 	return true
 }
 
-func (c *Controller) processItem(event *shared.Event) error {
-	//obj, _, err := c.informer.GetIndexer().GetByKey(event.Key)
-	//if err != nil {
-	//	return fmt.Errorf("error fetching object with key %s from store: %v", event.Key, err)
-	//}
-
+func (c *Controller) processItem(event *shared.Event) {
 	// get object's metedata
 	objectMeta := event.GetObjectMetaData()
 
-	// process events based on its type
-	switch event.Action {
-	case "create":
-		// compare CreationTimestamp and serverStartTime and alert only on latest events
-		// Could be Replaced by using Delta or DeltaFIFO
-		if objectMeta.CreationTimestamp.Sub(serverStartTime).Seconds() > 0 {
-			for _, handler := range c.handlers {
-				handler.Created(event)
+	// compare CreationTimestamp and startTime and alert only on latest events
+	// Could be Replaced by using Delta or DeltaFIFO
+	if event.Action == "create" && objectMeta.CreationTimestamp.Sub(c.startTime).Seconds() <= 0 {
+		return
+	}
+
+	if event.ResourceType == shared.ResourceTypeEvent && !c.acceptKubeEvent(event) {
+		return
+	}
+
+	// the resyncs and the updates only changing the ignored fields are not dispatched
+	if event.Action == "update" {
+		changes, err := shared.Diff(event.OldObject, event.Object)
+		if err != nil {
+			log.Errorf("diff of %s[%s] error: %s", c.resourceType, event.Key, err)
+		} else {
+			event.Diff = shared.IgnoreChanges(changes, g.Config().Kubernetes.IgnoredPaths(string(c.resourceType)))
+			if len(event.Diff) == 0 {
+				promeNoopUpdates.WithLabelValues(c.cluster, string(c.resourceType)).Inc()
+				return
 			}
-			return nil
 		}
-	case "update":
-		for _, handler := range c.handlers {
-			handler.Updated(event)
-		}
-		return nil
-	case "delete":
-		for _, handler := range c.handlers {
-			handler.Deleted(event)
-		}
-		return nil
 	}
-	return nil
+
+	c.owners.enrich(event)
+
+	// Give the event to the queue of each handler
+	c.dispatcher.Dispatch(event)
 }
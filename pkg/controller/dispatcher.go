@@ -0,0 +1,467 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/srelab/common/log"
+	"github.com/srelab/watcher/pkg/g"
+	"github.com/srelab/watcher/pkg/handlers/shared"
+	"github.com/srelab/watcher/pkg/routing"
+
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// Dispatcher gives every handler its own rate-limited queue and workers,
+// so a slow handler cannot stall the events of the other handlers
+type Dispatcher struct {
+	queues     []*handlerQueue
+	deadLetter DeadLetter
+	observers  []Observer
+
+	// guards the filters of the queues and the rules, which are replaced on reloads
+	lock  sync.RWMutex
+	rules *routing.Engine
+
+	// the parent of the contexts of the handler calls, cancelled when the shutdown times out
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// ErrShutdown is the error of the events given to the dead letter, since they were not processed before the shutdown
+var ErrShutdown = errors.New("not processed before the shutdown")
+
+// DeadLetter keeps the events a handler has given up after maxRetries
+type DeadLetter interface {
+	Put(event *shared.Event, handler string, attempts int, err error) error
+}
+
+// Every handler only receives the events matched by its filter in the config,
+// and by the rules targeting it, if any. The passive handlers receive no event
+func NewDispatcher(handlers shared.Handlers, config *g.Handlers) (*Dispatcher, error) {
+	active := make(shared.Handlers, 0, len(handlers))
+	for _, handler := range handlers {
+		if passive, ok := handler.(shared.Passive); ok && passive.Passive() {
+			continue
+		}
+
+		active = append(active, handler)
+	}
+
+	rules, err := newRules(active, config.Rules)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Dispatcher{rules: rules}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	for _, handler := range active {
+		workerConfig := config.GetWorkerConfig(handler.Name())
+
+		filter, err := shared.NewFilter(config.GetFilterConfig(handler.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("filter of handler[%s]: %s", handler.Name(), err)
+		}
+
+		d.queues = append(d.queues, &handlerQueue{
+			dispatcher: d,
+			handler:    handler,
+			filter:     filter,
+			workers:    workerConfig.Count,
+			timeout:    workerConfig.Timeout * time.Second,
+			queue:      workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), handler.Name()),
+			pending:    make(map[string][]*shared.Event),
+		})
+	}
+
+	return d, nil
+}
+
+// Dispatch adds the event to the queue of each handler whose filter matches the event
+func (d *Dispatcher) Dispatch(event *shared.Event) {
+	for _, observer := range d.observers {
+		observer.Dispatched(event)
+	}
+
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	routes := d.rules.Route(event)
+	for _, q := range d.queues {
+		if !q.filter.Match(event) {
+			continue
+		}
+
+		if d.rules.Targets(q.handler.Name()) && !routes[q.handler.Name()] {
+			continue
+		}
+
+		q.add(event)
+	}
+}
+
+// Compiles the rules, which must target existing handlers
+func newRules(handlers shared.Handlers, configs []g.RuleConfig) (*routing.Engine, error) {
+	rules, err := routing.New(configs)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for _, handler := range handlers {
+		names[handler.Name()] = true
+	}
+
+	for _, rule := range rules.Rules() {
+		for _, handler := range rule.Handlers {
+			if !names[handler] {
+				return nil, fmt.Errorf("rule[%s] targets handler[%s], which does not exist", rule.Name, handler)
+			}
+		}
+	}
+
+	return rules, nil
+}
+
+// Rules returns the routing rules in use
+func (d *Dispatcher) Rules() *routing.Engine {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	return d.rules
+}
+
+// Reload replaces the filters of the handlers and the rules, nothing is changed when any of them
+// is invalid. The workers of the handlers are only configured at the start
+func (d *Dispatcher) Reload(config *g.Handlers) error {
+	handlers := make(shared.Handlers, 0, len(d.queues))
+	for _, q := range d.queues {
+		handlers = append(handlers, q.handler)
+	}
+
+	rules, err := newRules(handlers, config.Rules)
+	if err != nil {
+		return err
+	}
+
+	filters := make([]*shared.Filter, len(d.queues))
+	for i, q := range d.queues {
+		filter, err := shared.NewFilter(config.GetFilterConfig(q.handler.Name()))
+		if err != nil {
+			return fmt.Errorf("filter of handler[%s]: %s", q.handler.Name(), err)
+		}
+		filters[i] = filter
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for i, q := range d.queues {
+		q.filter = filters[i]
+	}
+	d.rules = rules
+
+	return nil
+}
+
+// Observer is told about every dispatched event, and about the outcome of each call of a handler
+type Observer interface {
+	Dispatched(event *shared.Event)
+	Handled(event *shared.Event, handler string, err error)
+}
+
+// AddObserver adds the observer, it must be added before Run
+func (d *Dispatcher) AddObserver(observer Observer) {
+	d.observers = append(d.observers, observer)
+}
+
+// SetDeadLetter sets where the given up events are kept, they are only logged without it
+func (d *Dispatcher) SetDeadLetter(deadLetter DeadLetter) {
+	d.deadLetter = deadLetter
+}
+
+// Remove removes the queue of the handler, it must be called before Run
+func (d *Dispatcher) Remove(handler string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for i, q := range d.queues {
+		if q.handler.Name() == handler {
+			d.queues = append(d.queues[:i], d.queues[i+1:]...)
+			return
+		}
+	}
+}
+
+// Replay adds the event to the queue of the named handler only
+func (d *Dispatcher) Replay(handler string, event *shared.Event) error {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	for _, q := range d.queues {
+		if q.handler.Name() == handler {
+			q.add(event)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("handler[%s] does not exist", handler)
+}
+
+// Run starts the workers of every handler and blocks until stopCh is closed,
+// Shutdown stops the workers after processing the queued events instead
+func (d *Dispatcher) Run(stopCh <-chan struct{}) {
+	defer utilruntime.HandleCrash()
+
+	for _, q := range d.queues {
+		for i := 0; i < q.workers; i++ {
+			go wait.Until(q.runWorker, time.Second, stopCh)
+		}
+	}
+
+	<-stopCh
+	for _, q := range d.queues {
+		q.queue.ShutDown()
+	}
+}
+
+// Shutdown waits for the queued events to be processed until ctx is done, then stops the workers and
+// cancels the handler calls in flight. The events left are given to the dead letter, so they can be replayed.
+// The controllers must be stopped first, no event is dispatched anymore
+func (d *Dispatcher) Shutdown(ctx context.Context) {
+	if !waitFor(ctx, d.drained) {
+		log.Warnf("shutdown timed out, cancelling the handler calls in flight")
+	}
+
+	for _, q := range d.queues {
+		q.queue.ShutDown()
+	}
+	d.cancel()
+
+	// the cancelled calls return their event to the queue as failed
+	graceCtx, graceCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer graceCancel()
+	waitFor(graceCtx, d.idle)
+
+	count := 0
+	for _, q := range d.queues {
+		q.lock.Lock()
+		for key, events := range q.pending {
+			for _, event := range events {
+				count++
+				if d.deadLetter == nil {
+					log.Errorf("event %s of handler[%s] is dropped at the shutdown", event.Key, q.handler.Name())
+					continue
+				}
+
+				if err := d.deadLetter.Put(event, q.handler.Name(), 0, ErrShutdown); err != nil {
+					log.Errorf("put %s of handler[%s] to dead letter error: %v", event.Key, q.handler.Name(), err)
+				}
+			}
+			delete(q.pending, key)
+		}
+		q.lock.Unlock()
+	}
+
+	log.Infof("dispatcher stopped, %d events were not processed", count)
+}
+
+// Returns true when every queued event has been processed
+func (d *Dispatcher) drained() bool {
+	for _, q := range d.queues {
+		q.lock.Lock()
+		pending := len(q.pending)
+		q.lock.Unlock()
+
+		if pending > 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Returns true when no handler call is in flight, the workers take the keys left in a queue after it is shut down
+func (d *Dispatcher) idle() bool {
+	for _, q := range d.queues {
+		if q.queue.Len() > 0 || atomic.LoadInt32(&q.inflight) > 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Polls the condition until it is true or ctx is done, returns the last result
+func waitFor(ctx context.Context, condition func() bool) bool {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for !condition() {
+		select {
+		case <-ctx.Done():
+			return condition()
+		case <-ticker.C:
+		}
+	}
+
+	return true
+}
+
+// The queue holds the keys of the objects, the events of a key wait in pending.
+// A key is never processed by two workers at the same time, which keeps the events
+// of an object in order while the events of different objects are processed concurrently
+type handlerQueue struct {
+	dispatcher *Dispatcher
+	handler    shared.Handler
+	filter     *shared.Filter
+	workers    int
+	timeout    time.Duration
+
+	queue workqueue.RateLimitingInterface
+
+	// the number of the handler calls in flight
+	inflight int32
+
+	lock    sync.Mutex
+	pending map[string][]*shared.Event
+}
+
+// Returns the key of the object in the queue, the same key may exist in several clusters
+func queueKey(event *shared.Event) string {
+	return event.Cluster + "/" + string(event.ResourceType) + "/" + event.Key
+}
+
+func (q *handlerQueue) add(event *shared.Event) {
+	key := queueKey(event)
+
+	q.lock.Lock()
+	q.pending[key] = append(q.pending[key], event)
+	q.lock.Unlock()
+
+	// the key of a failed event waits for its rate-limited retry, the new event waits behind it.
+	// The worker forgets the key before it pops the event, so the key is not left out of the queue
+	if q.queue.NumRequeues(key) > 0 {
+		return
+	}
+
+	q.queue.Add(key)
+}
+
+// Returns the oldest event of the key
+func (q *handlerQueue) peek(key string) *shared.Event {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if len(q.pending[key]) == 0 {
+		return nil
+	}
+
+	return q.pending[key][0]
+}
+
+// Removes the oldest event of the key and returns the number of the remaining events
+func (q *handlerQueue) pop(key string) int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	events := q.pending[key]
+	if len(events) <= 1 {
+		delete(q.pending, key)
+		return 0
+	}
+
+	q.pending[key] = events[1:]
+	return len(q.pending[key])
+}
+
+func (q *handlerQueue) runWorker() {
+	for q.processNextItem() {
+		// continue looping
+	}
+}
+
+func (q *handlerQueue) processNextItem() bool {
+	item, quit := q.queue.Get()
+
+	if quit {
+		return false
+	}
+	defer q.queue.Done(item)
+
+	key := item.(string)
+	event := q.peek(key)
+	if event == nil {
+		q.queue.Forget(key)
+		return true
+	}
+
+	err := q.processItem(event)
+	for _, observer := range q.dispatcher.observers {
+		observer.Handled(event, q.handler.Name(), err)
+	}
+
+	if err == nil {
+		// No error, reset the ratelimit counters
+		q.queue.Forget(key)
+	} else if q.queue.NumRequeues(key) < maxRetries {
+		// the event stays at the head of the key, the later events wait for it
+		log.Errorf("error processing %s by handler[%s] (will retry): %v", event.Key, q.handler.Name(), err)
+		q.queue.AddRateLimited(key)
+		return true
+	} else {
+		// err != nil and too many retries
+		log.Errorf("error processing %s by handler[%s] (giving up): %v", event.Key, q.handler.Name(), err)
+		if q.dispatcher.deadLetter != nil {
+			if err := q.dispatcher.deadLetter.Put(event, q.handler.Name(), q.queue.NumRequeues(key)+1, err); err != nil {
+				log.Errorf("put %s of handler[%s] to dead letter error: %v", event.Key, q.handler.Name(), err)
+			}
+		}
+
+		q.queue.Forget(key)
+		utilruntime.HandleError(err)
+	}
+
+	// the next event of the key is waiting
+	if q.pop(key) > 0 {
+		q.queue.Add(key)
+	}
+
+	return true
+}
+
+func (q *handlerQueue) processItem(event *shared.Event) (err error) {
+	ctx, cancel := context.WithTimeout(q.dispatcher.ctx, q.timeout)
+	defer cancel()
+
+	atomic.AddInt32(&q.inflight, 1)
+	defer atomic.AddInt32(&q.inflight, -1)
+
+	start := time.Now()
+	defer func() {
+		outcome := "success"
+		if err != nil {
+			outcome = "error"
+		}
+
+		promeHandlerDuration.WithLabelValues(q.handler.Name(), event.Action).Observe(time.Since(start).Seconds())
+		promeHandlerCalls.WithLabelValues(q.handler.Name(), event.Action, outcome).Inc()
+	}()
+
+	switch event.Action {
+	case "create":
+		return q.handler.Created(ctx, event)
+	case "update":
+		return q.handler.Updated(ctx, event)
+	case "delete":
+		return q.handler.Deleted(ctx, event)
+	}
+
+	return nil
+}
@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/srelab/watcher/pkg/g"
+	"github.com/srelab/watcher/pkg/handlers/shared"
+)
+
+// deduper keeps when the events of an involved object with a reason were last dispatched,
+// a repeated event (e.g. BackOff) is an update of the same Event object with a higher count
+type deduper struct {
+	lock sync.Mutex
+	seen map[string]time.Time
+}
+
+func newDeduper() *deduper {
+	return &deduper{seen: make(map[string]time.Time)}
+}
+
+// Returns true when the Kubernetes Event is dispatched. The deletions of the Events, which
+// expire after an hour, are not dispatched, nor the Events of the types and reasons not selected.
+// The Events of core/v1 and events.k8s.io are selected alike
+func (c *Controller) acceptKubeEvent(event *shared.Event) bool {
+	kubeEvent, ok := shared.CoreEvent(event.Object)
+	if !ok {
+		return true
+	}
+
+	config := g.Config().Kubernetes.Events
+	if event.Action == "delete" || !config.Selects(kubeEvent.Type, kubeEvent.Reason) {
+		return false
+	}
+
+	if config.DedupeWindow <= 0 {
+		return true
+	}
+
+	key := strings.Join([]string{
+		kubeEvent.InvolvedObject.Namespace, kubeEvent.InvolvedObject.Kind, kubeEvent.InvolvedObject.Name, kubeEvent.Reason,
+	}, "/")
+
+	c.deduper.lock.Lock()
+	defer c.deduper.lock.Unlock()
+
+	now := time.Now()
+	if last, ok := c.deduper.seen[key]; ok && now.Sub(last) < config.DedupeWindow*time.Second {
+		promeKubeEventsDeduplicated.WithLabelValues(c.cluster, kubeEvent.Reason).Inc()
+		return false
+	}
+
+	// the expired keys are dropped as the map grows
+	if len(c.deduper.seen) > 1000 {
+		for seenKey, last := range c.deduper.seen {
+			if now.Sub(last) >= config.DedupeWindow*time.Second {
+				delete(c.deduper.seen, seenKey)
+			}
+		}
+	}
+
+	c.deduper.seen[key] = now
+	return true
+}
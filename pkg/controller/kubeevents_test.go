@@ -0,0 +1,71 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/srelab/watcher/pkg/g"
+	"github.com/srelab/watcher/pkg/handlers/shared"
+
+	apiV1 "k8s.io/api/core/v1"
+	eventsV1Beta1 "k8s.io/api/events/v1beta1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAcceptKubeEvent(t *testing.T) {
+	involved := apiV1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "web"}
+	core := func(eventType, reason string) *shared.Event {
+		return &shared.Event{Action: "create", ResourceType: shared.ResourceTypeEvent, Object: &apiV1.Event{
+			ObjectMeta: metaV1.ObjectMeta{Name: "web.1"}, InvolvedObject: involved, Type: eventType, Reason: reason,
+		}}
+	}
+	events := func(eventType, reason string) *shared.Event {
+		return &shared.Event{Action: "create", ResourceType: shared.ResourceTypeEvent, Object: &eventsV1Beta1.Event{
+			ObjectMeta: metaV1.ObjectMeta{Name: "web.2"}, Regarding: involved, Type: eventType, Reason: reason,
+		}}
+	}
+
+	tests := []struct {
+		name   string
+		config g.KubeEvents
+		events []*shared.Event
+		want   []bool
+	}{
+		{
+			name:   "types are selected",
+			config: g.KubeEvents{Types: []string{"Warning"}},
+			events: []*shared.Event{core("Normal", "Pulled"), core("Warning", "BackOff"), events("Normal", "Pulled"), events("Warning", "BackOff")},
+			want:   []bool{false, true, false, true},
+		},
+		{
+			name:   "excluded reasons",
+			config: g.KubeEvents{ExcludeReasons: []string{"BackOff"}},
+			events: []*shared.Event{core("Warning", "BackOff"), events("Warning", "BackOff"), events("Warning", "Unhealthy")},
+			want:   []bool{false, false, true},
+		},
+		{
+			name:   "repeated events are deduplicated across the API groups",
+			config: g.KubeEvents{DedupeWindow: 600},
+			events: []*shared.Event{core("Warning", "BackOff"), events("Warning", "BackOff"), events("Warning", "Unhealthy")},
+			want:   []bool{true, false, true},
+		},
+		{
+			name:   "deletions are not dispatched",
+			config: g.KubeEvents{},
+			events: []*shared.Event{{Action: "delete", ResourceType: shared.ResourceTypeEvent, Object: &eventsV1Beta1.Event{Regarding: involved}}},
+			want:   []bool{false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g.SetConfig(&g.Configuration{Kubernetes: &g.Kubernetes{Events: tt.config}})
+
+			c := &Controller{cluster: "test", deduper: newDeduper()}
+			for i, event := range tt.events {
+				if got := c.acceptKubeEvent(event); got != tt.want[i] {
+					t.Errorf("event %d: acceptKubeEvent() = %v, want %v", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package controller
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/srelab/watcher/pkg/g"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// prometheus collector
+var (
+	promeTombstones = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: strings.ToLower(g.NAME),
+		Subsystem: "controller",
+		Name:      "tombstones_total",
+		Help:      "Number of the deletions missed by the informers and delivered as tombstones with the last known state.",
+	}, []string{"cluster", "resource"})
+
+	promeCoalesced = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: strings.ToLower(g.NAME),
+		Subsystem: "controller",
+		Name:      "coalesced_updates_total",
+		Help:      "Number of the update events merged into a pending update of the same object.",
+	}, []string{"cluster", "resource"})
+
+	promeNoopUpdates = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: strings.ToLower(g.NAME),
+		Subsystem: "controller",
+		Name:      "noop_updates_total",
+		Help:      "Number of the update events not dispatched, since only ignored fields have changed.",
+	}, []string{"cluster", "resource"})
+
+	promeKubeEventsDeduplicated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: strings.ToLower(g.NAME),
+		Subsystem: "controller",
+		Name:      "kube_events_deduplicated_total",
+		Help:      "Number of the Kubernetes Events not dispatched, since the same reason of the involved object was dispatched within the window.",
+	}, []string{"cluster", "reason"})
+
+	promeInformerSynced = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: strings.ToLower(g.NAME),
+		Subsystem: "informer",
+		Name:      "synced",
+		Help:      "Whether the informers of the resource have synced, 0 when they are not running.",
+	}, []string{"cluster", "resource"})
+
+	promeInformerResourceVersion = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: strings.ToLower(g.NAME),
+		Subsystem: "informer",
+		Name:      "last_resource_version",
+		Help:      "The latest resourceVersion observed by the informers of the resource.",
+	}, []string{"cluster", "resource"})
+
+	promeHandlerDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: strings.ToLower(g.NAME),
+		Subsystem: "handler",
+		Name:      "duration_seconds",
+		Help:      "Latency of each call of the handlers, by handler and action.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"handler", "action"})
+
+	promeHandlerCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: strings.ToLower(g.NAME),
+		Subsystem: "handler",
+		Name:      "calls_total",
+		Help:      "Number of the calls of the handlers, by handler, action and outcome (success or error).",
+	}, []string{"handler", "action", "outcome"})
+)
+
+func init() {
+	prometheus.MustRegister(promeTombstones, promeCoalesced, promeNoopUpdates, promeKubeEventsDeduplicated, promeInformerSynced, promeInformerResourceVersion, promeHandlerDuration, promeHandlerCalls)
+	workqueue.SetProvider(newWorkqueueMetrics())
+}
+
+// Returns the resourceVersion as a number, the apiserver backed by etcd3 always uses numbers
+func resourceVersionValue(resourceVersion string) float64 {
+	value, _ := strconv.ParseFloat(resourceVersion, 64)
+	return value
+}
+
+// workqueueMetrics exposes the metrics of the named workqueues, the controller queue of each
+// resource type (<cluster>/<resource>) and the queue of each handler (<handler>)
+type workqueueMetrics struct {
+	depth                   *prometheus.GaugeVec
+	adds                    *prometheus.CounterVec
+	latency                 *prometheus.HistogramVec
+	workDuration            *prometheus.HistogramVec
+	unfinishedWorkSeconds   *prometheus.GaugeVec
+	longestRunningProcessor *prometheus.GaugeVec
+	retries                 *prometheus.CounterVec
+}
+
+func newWorkqueueMetrics() *workqueueMetrics {
+	namespace := strings.ToLower(g.NAME)
+
+	m := &workqueueMetrics{
+		depth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace, Subsystem: "workqueue", Name: "depth",
+			Help: "Current depth of the workqueue.",
+		}, []string{"name"}),
+		adds: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "workqueue", Name: "adds_total",
+			Help: "Number of the adds handled by the workqueue.",
+		}, []string{"name"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Subsystem: "workqueue", Name: "queue_duration_seconds",
+			Help: "How long an item stays in the workqueue before being requested.", Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+		}, []string{"name"}),
+		workDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Subsystem: "workqueue", Name: "work_duration_seconds",
+			Help: "How long processing an item from the workqueue takes.", Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+		}, []string{"name"}),
+		unfinishedWorkSeconds: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace, Subsystem: "workqueue", Name: "unfinished_work_seconds",
+			Help: "How many seconds of work has been done that is in progress.",
+		}, []string{"name"}),
+		longestRunningProcessor: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace, Subsystem: "workqueue", Name: "longest_running_processor_seconds",
+			Help: "How many seconds the longest running processor of the workqueue has been running.",
+		}, []string{"name"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Subsystem: "workqueue", Name: "retries_total",
+			Help: "Number of the retries handled by the workqueue.",
+		}, []string{"name"}),
+	}
+
+	prometheus.MustRegister(m.depth, m.adds, m.latency, m.workDuration, m.unfinishedWorkSeconds, m.longestRunningProcessor, m.retries)
+	return m
+}
+
+func (m *workqueueMetrics) NewDepthMetric(name string) workqueue.GaugeMetric {
+	return m.depth.WithLabelValues(name)
+}
+
+func (m *workqueueMetrics) NewAddsMetric(name string) workqueue.CounterMetric {
+	return m.adds.WithLabelValues(name)
+}
+
+func (m *workqueueMetrics) NewLatencyMetric(name string) workqueue.HistogramMetric {
+	return m.latency.WithLabelValues(name)
+}
+
+func (m *workqueueMetrics) NewWorkDurationMetric(name string) workqueue.HistogramMetric {
+	return m.workDuration.WithLabelValues(name)
+}
+
+func (m *workqueueMetrics) NewUnfinishedWorkSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return m.unfinishedWorkSeconds.WithLabelValues(name)
+}
+
+func (m *workqueueMetrics) NewLongestRunningProcessorSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return m.longestRunningProcessor.WithLabelValues(name)
+}
+
+func (m *workqueueMetrics) NewRetriesMetric(name string) workqueue.CounterMetric {
+	return m.retries.WithLabelValues(name)
+}
+
+// The deprecated metrics of client-go are not exposed
+type noopMetric struct{}
+
+func (noopMetric) Inc()            {}
+func (noopMetric) Dec()            {}
+func (noopMetric) Set(float64)     {}
+func (noopMetric) Observe(float64) {}
+
+func (m *workqueueMetrics) NewDeprecatedDepthMetric(name string) workqueue.GaugeMetric {
+	return noopMetric{}
+}
+
+func (m *workqueueMetrics) NewDeprecatedAddsMetric(name string) workqueue.CounterMetric {
+	return noopMetric{}
+}
+
+func (m *workqueueMetrics) NewDeprecatedLatencyMetric(name string) workqueue.SummaryMetric {
+	return noopMetric{}
+}
+
+func (m *workqueueMetrics) NewDeprecatedWorkDurationMetric(name string) workqueue.SummaryMetric {
+	return noopMetric{}
+}
+
+func (m *workqueueMetrics) NewDeprecatedUnfinishedWorkSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return noopMetric{}
+}
+
+func (m *workqueueMetrics) NewDeprecatedLongestRunningProcessorMicrosecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return noopMetric{}
+}
+
+func (m *workqueueMetrics) NewDeprecatedRetriesMetric(name string) workqueue.CounterMetric {
+	return noopMetric{}
+}
@@ -0,0 +1,153 @@
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"github.com/srelab/common/log"
+	"github.com/srelab/watcher/pkg/g"
+	"github.com/srelab/watcher/pkg/handlers/shared"
+	"github.com/srelab/watcher/pkg/kube"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// the owner chain is cut after this many owners, which also stops the owner reference loops
+const maxOwners = 5
+
+// owners resolves the owner chain of the objects of a cluster. The owners of the objects of a workload
+// are the same, so they are cached by the UID, the owners which cannot be read are cached as nil
+type owners struct {
+	cluster *kube.Cluster
+
+	lock  sync.Mutex
+	cache map[types.UID]*cachedOwner
+}
+
+type cachedOwner struct {
+	object  metaV1.Object
+	expires time.Time
+}
+
+func newOwners(cluster *kube.Cluster) *owners {
+	return &owners{cluster: cluster, cache: make(map[types.UID]*cachedOwner)}
+}
+
+// Adds the owner chain and the selected labels and annotations to the event
+func (o *owners) enrich(event *shared.Event) {
+	config := g.Config().Kubernetes.Owners
+	if !config.Enable {
+		return
+	}
+
+	object, err := meta.Accessor(event.Object)
+	if err != nil {
+		return
+	}
+
+	objects := []metaV1.Object{object}
+	for ref := metaV1.GetControllerOf(object); ref != nil && len(event.Owners) < maxOwners; ref = metaV1.GetControllerOf(object) {
+		if object = o.get(object.GetNamespace(), ref, config.CacheTTL*time.Second); object == nil {
+			break
+		}
+
+		objects = append(objects, object)
+		event.Owners = append(event.Owners, shared.Owner{APIVersion: ref.APIVersion, Kind: ref.Kind, Name: ref.Name})
+	}
+
+	// the object wins over its owners, the closest owner over the farther ones
+	for i := len(objects) - 1; i >= 0; i-- {
+		for _, key := range config.Labels {
+			if value, ok := objects[i].GetLabels()[key]; ok {
+				setLabel(event, key, value)
+			}
+		}
+
+		for _, key := range config.Annotations {
+			if value, ok := objects[i].GetAnnotations()[key]; ok {
+				setLabel(event, key, value)
+			}
+		}
+	}
+}
+
+func setLabel(event *shared.Event, key, value string) {
+	if event.Labels == nil {
+		event.Labels = make(map[string]string)
+	}
+
+	event.Labels[key] = value
+}
+
+// Returns the owner referenced by the object of the namespace, nil when it cannot be read,
+// e.g. it has been deleted before the event of the object or its kind is unknown
+func (o *owners) get(namespace string, ref *metaV1.OwnerReference, ttl time.Duration) metaV1.Object {
+	if object, ok := o.cached(ref.UID); ok {
+		return object
+	}
+
+	// the owner is read without the lock, the events of the other objects are not blocked by
+	// the API server; the owner may be read twice by concurrent events, the last read is cached
+	object, err := o.read(namespace, ref)
+	if err != nil {
+		log.Debugf("owner %s[%s] in cluster[%s] cannot be read: %s", ref.Kind, ref.Name, o.cluster.Name, err)
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	// the expired owners are dropped as the cache grows
+	if len(o.cache) > 1000 {
+		for uid, cached := range o.cache {
+			if time.Now().After(cached.expires) {
+				delete(o.cache, uid)
+			}
+		}
+	}
+
+	o.cache[ref.UID] = &cachedOwner{object: object, expires: time.Now().Add(ttl)}
+	return object
+}
+
+func (o *owners) cached(uid types.UID) (metaV1.Object, bool) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if cached, ok := o.cache[uid]; ok && time.Now().Before(cached.expires) {
+		return cached.object, true
+	}
+
+	return nil, false
+}
+
+func (o *owners) read(namespace string, ref *metaV1.OwnerReference) (metaV1.Object, error) {
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	mapping, err := o.cluster.Mapper.RESTMapping(schema.GroupKind{Group: gv.Group, Kind: ref.Kind}, gv.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	// the owner of a namespaced object is in the same namespace or cluster-scoped
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		namespace = metaV1.NamespaceNone
+	}
+
+	object, err := o.cluster.Dynamic.Resource(mapping.Resource).Namespace(namespace).Get(ref.Name, metaV1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	// a new object of the same name is not the owner
+	if object.GetUID() != ref.UID {
+		return nil, nil
+	}
+
+	return object, nil
+}
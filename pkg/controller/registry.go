@@ -0,0 +1,108 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/srelab/watcher/pkg/handlers/shared"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Registry keeps the running controllers of every cluster,
+// it gives the handlers access to the objects cached by their informers
+type Registry struct {
+	clusters []string
+
+	lock        sync.RWMutex
+	controllers []*Controller
+}
+
+// The objects of a resource type are only listed when it is watched in all the clusters
+func NewRegistry(clusters []string) *Registry {
+	return &Registry{clusters: clusters}
+}
+
+// Register adds the controller until stopCh is closed
+func (r *Registry) Register(c *Controller, stopCh <-chan struct{}) {
+	r.lock.Lock()
+	r.controllers = append(r.controllers, c)
+	r.lock.Unlock()
+
+	go func() {
+		<-stopCh
+
+		r.lock.Lock()
+		defer r.lock.Unlock()
+
+		for i, controller := range r.controllers {
+			if controller == c {
+				r.controllers = append(r.controllers[:i], r.controllers[i+1:]...)
+				break
+			}
+		}
+	}()
+}
+
+// WaitForCacheSync waits for the informers of all the registered controllers to sync,
+// false is returned when stopCh is closed first
+func (r *Registry) WaitForCacheSync(stopCh <-chan struct{}) bool {
+	r.lock.RLock()
+	synced := make([]cache.InformerSynced, 0, len(r.controllers))
+	for _, c := range r.controllers {
+		synced = append(synced, c.HasSynced)
+	}
+	r.lock.RUnlock()
+
+	return cache.WaitForCacheSync(stopCh, synced...)
+}
+
+// Check implements shared.HealthChecker, an error is returned until the informers of
+// all the registered controllers have synced. Nothing is registered when not leading
+func (r *Registry) Check(ctx context.Context) error {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	var unsynced []string
+	for _, c := range r.controllers {
+		if !c.HasSynced() {
+			unsynced = append(unsynced, c.cluster+"/"+string(c.resourceType))
+		}
+	}
+
+	if len(unsynced) > 0 {
+		return fmt.Errorf("informers have not synced: %s", strings.Join(unsynced, ", "))
+	}
+
+	return nil
+}
+
+// List implements shared.Lister
+func (r *Registry) List(resourceType shared.ResourceType) ([]*shared.Event, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	events := make([]*shared.Event, 0)
+	for _, cluster := range r.clusters {
+		var controller *Controller
+		for _, c := range r.controllers {
+			if c.cluster == cluster && c.resourceType == resourceType {
+				controller = c
+				break
+			}
+		}
+
+		if controller == nil {
+			return nil, fmt.Errorf("%s is not watched in cluster[%s]", resourceType, cluster)
+		}
+
+		if !controller.HasSynced() {
+			return nil, fmt.Errorf("%s of cluster[%s] has not synced", resourceType, cluster)
+		}
+
+		events = append(events, controller.List()...)
+	}
+
+	return events, nil
+}
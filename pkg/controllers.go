@@ -0,0 +1,183 @@
+package pkg
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/srelab/common/log"
+	"github.com/srelab/watcher/pkg/controller"
+	"github.com/srelab/watcher/pkg/g"
+	"github.com/srelab/watcher/pkg/handlers/shared"
+	"github.com/srelab/watcher/pkg/kube"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// controllerSet runs the controllers of every cluster while leading. Each controller has its own
+// stop channel, so a reload only starts and stops the controllers of the changed resources
+type controllerSet struct {
+	clusters   kube.Clusters
+	dispatcher *controller.Dispatcher
+	registry   *controller.Registry
+
+	lock    sync.Mutex
+	stopCh  <-chan struct{}
+	running map[string]chan struct{}
+
+	// the controllers which have not returned yet
+	wg sync.WaitGroup
+}
+
+// a resource of a cluster, with the namespaces of its informers
+type controllerSpec struct {
+	cluster    *kube.Cluster
+	gvr        schema.GroupVersionResource
+	kind       shared.ResourceType
+	namespaces []string
+}
+
+func (spec controllerSpec) key() string {
+	return strings.Join([]string{
+		spec.cluster.Name, spec.gvr.Group, spec.gvr.Version, spec.gvr.Resource, strings.Join(spec.namespaces, ","),
+	}, "/")
+}
+
+func newControllerSet(clusters kube.Clusters, dispatcher *controller.Dispatcher, registry *controller.Registry) *controllerSet {
+	return &controllerSet{clusters: clusters, dispatcher: dispatcher, registry: registry}
+}
+
+// Run starts the controllers of the current config, they are all stopped when stopCh is closed.
+// The informers are recreated on each call, since an informer cannot be restarted once stopped
+func (s *controllerSet) Run(stopCh <-chan struct{}) {
+	s.lock.Lock()
+	s.stopCh = stopCh
+	s.running = make(map[string]chan struct{})
+	s.lock.Unlock()
+
+	s.Sync(g.Config())
+
+	go func() {
+		<-stopCh
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		if s.stopCh == stopCh {
+			s.stopCh, s.running = nil, nil
+		}
+	}()
+}
+
+// Sync stops the controllers of the resources removed from the config and starts those of the added ones,
+// a controller whose informer namespaces have changed is restarted. Nothing is done when not leading
+func (s *controllerSet) Sync(config *g.Configuration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.stopCh == nil {
+		return
+	}
+
+	wanted := make(map[string]controllerSpec)
+	for _, cluster := range s.clusters {
+		for _, spec := range controllerSpecs(cluster, config) {
+			wanted[spec.key()] = spec
+		}
+	}
+
+	for key, stop := range s.running {
+		if _, ok := wanted[key]; !ok {
+			log.Infof("stopping controller[%s]", key)
+			close(stop)
+			delete(s.running, key)
+		}
+	}
+
+	for key, spec := range wanted {
+		if _, ok := s.running[key]; !ok {
+			s.running[key] = s.start(spec)
+		}
+	}
+}
+
+// Every configured resource is watched through the dynamic informers, the namespaced resources
+// by an informer per watched namespace, the cluster-scoped resources by a cluster-wide informer
+func controllerSpecs(cluster *kube.Cluster, config *g.Configuration) []controllerSpec {
+	specs := make([]controllerSpec, 0, len(config.Resource))
+	for _, resource := range config.Resource {
+		gvr := resource.GroupVersionResource()
+
+		mapping, err := cluster.RESTMapping(gvr)
+		if err != nil {
+			log.Errorf("resource[%s] cannot be watched in cluster[%s]: %s", gvr.String(), cluster.Name, err)
+			continue
+		}
+
+		namespaces := config.Kubernetes.InformerNamespaces()
+		if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+			namespaces = []string{metaV1.NamespaceAll}
+		}
+
+		specs = append(specs, controllerSpec{
+			cluster:    cluster,
+			gvr:        gvr,
+			kind:       shared.ResourceType(mapping.GroupVersionKind.Kind),
+			namespaces: namespaces,
+		})
+	}
+
+	return specs
+}
+
+// Starts the controller of the spec, it is stopped when the returned channel or the stopCh of the leadership is closed
+func (s *controllerSet) start(spec controllerSpec) chan struct{} {
+	stop := make(chan struct{})
+	stopCh := make(chan struct{})
+	go func(leading <-chan struct{}) {
+		select {
+		case <-leading:
+		case <-stop:
+		}
+
+		close(stopCh)
+	}(s.stopCh)
+
+	informers := make([]cache.SharedIndexInformer, 0, len(spec.namespaces))
+	for _, namespace := range spec.namespaces {
+		informers = append(informers, dynamicinformer.NewFilteredDynamicInformer(
+			spec.cluster.Dynamic, spec.gvr, namespace, 0, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}, nil,
+		).Informer())
+	}
+
+	c := controller.New(spec.cluster, informers, spec.kind, s.dispatcher)
+	s.registry.Register(c, stopCh)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		c.Run(stopCh)
+	}()
+
+	return stop
+}
+
+// Wait waits for the stopped controllers to dispatch their queued events until ctx is done
+func (s *controllerSet) Wait(ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,250 @@
+package election
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/srelab/common/log"
+	"github.com/srelab/watcher/pkg/g"
+	"go.etcd.io/etcd/clientv3"
+	"go.etcd.io/etcd/clientv3/concurrency"
+
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	BackendLease = "lease"
+	BackendEtcd  = "etcd"
+
+	// the prefix of the election keys when using the etcd backend
+	etcdElectionPrefix = "/watcher/election"
+)
+
+// prometheus collector
+var promeIsLeader = prometheus.NewGauge(prometheus.GaugeOpts{
+	Namespace: strings.ToLower(g.NAME),
+	Subsystem: "leader_election",
+	Name:      "is_leader",
+	Help:      "Whether this replica is the leader and runs the controllers.",
+})
+
+func init() {
+	prometheus.MustRegister(promeIsLeader)
+}
+
+// Election makes sure only one of the watcher replicas runs the controllers,
+// using a Lease object or an etcd lock. The replica is always the leader when leader election is disabled
+type Election struct {
+	config   *g.LeaderElection
+	identity string
+
+	kubeClient kubernetes.Interface
+	etcdClient *clientv3.Client
+
+	lock    sync.RWMutex
+	leading bool
+	leader  string
+
+	logger log.Logger
+}
+
+func New(config *g.LeaderElection, kubeClient kubernetes.Interface, etcdClient *clientv3.Client) (*Election, error) {
+	identity := config.Identity
+	if identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, err
+		}
+
+		identity = hostname
+	}
+
+	if config.Enable && config.Backend == BackendEtcd && etcdClient == nil {
+		return nil, errors.New("the etcd backend requires the etcd handler")
+	}
+
+	return &Election{
+		config:     config,
+		identity:   identity,
+		kubeClient: kubeClient,
+		etcdClient: etcdClient,
+		logger:     log.With("election", config.Backend),
+	}, nil
+}
+
+// Run blocks until ctx is done. Every time this replica becomes the leader, run is called
+// with a channel that is closed as soon as the leadership is lost.
+func (e *Election) Run(ctx context.Context, run func(stopCh <-chan struct{})) {
+	if !e.config.Enable {
+		e.setLeading(true)
+		e.setLeader(e.identity)
+
+		run(ctx.Done())
+		return
+	}
+
+	for {
+		switch e.config.Backend {
+		case BackendEtcd:
+			e.runEtcd(ctx, run)
+		default:
+			e.runLease(ctx, run)
+		}
+
+		// campaign again after the leadership was lost
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(e.config.RetryPeriod * time.Second):
+		}
+	}
+}
+
+func (e *Election) runLease(ctx context.Context, run func(stopCh <-chan struct{})) {
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta:  metaV1.ObjectMeta{Namespace: e.config.Namespace, Name: e.config.Name},
+			Client:     e.kubeClient.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: e.identity},
+		},
+		LeaseDuration:   e.config.LeaseDuration * time.Second,
+		RenewDeadline:   e.config.RenewDeadline * time.Second,
+		RetryPeriod:     e.config.RetryPeriod * time.Second,
+		ReleaseOnCancel: true,
+		Name:            e.config.Name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				e.logger.Infof("[%s] started leading", e.identity)
+				e.setLeading(true)
+
+				run(ctx.Done())
+			},
+			OnStoppedLeading: func() {
+				e.logger.Infof("[%s] stopped leading", e.identity)
+				e.setLeading(false)
+			},
+			OnNewLeader: e.setLeader,
+		},
+	})
+
+	if err != nil {
+		e.logger.Errorf("an error occurred while creating the leader elector: %s", err)
+		return
+	}
+
+	elector.Run(ctx)
+}
+
+func (e *Election) runEtcd(ctx context.Context, run func(stopCh <-chan struct{})) {
+	session, err := concurrency.NewSession(
+		e.etcdClient,
+		concurrency.WithTTL(int(e.config.LeaseDuration)),
+		concurrency.WithContext(ctx),
+	)
+
+	if err != nil {
+		e.logger.Errorf("an error occurred while creating the etcd session: %s", err)
+		return
+	}
+	defer session.Close()
+
+	election := concurrency.NewElection(session, path.Join(etcdElectionPrefix, e.config.Name))
+
+	observeCtx, cancelObserve := context.WithCancel(ctx)
+	defer cancelObserve()
+
+	go func() {
+		for response := range election.Observe(observeCtx) {
+			if len(response.Kvs) > 0 {
+				e.setLeader(string(response.Kvs[0].Value))
+			}
+		}
+	}()
+
+	// blocks until this replica is elected
+	if err := election.Campaign(ctx, e.identity); err != nil {
+		e.logger.Errorf("an error occurred while campaigning: %s", err)
+		return
+	}
+
+	e.logger.Infof("[%s] started leading", e.identity)
+	e.setLeading(true)
+
+	leaderCtx, cancel := context.WithCancel(ctx)
+	run(leaderCtx.Done())
+
+	select {
+	case <-session.Done():
+		e.logger.Errorf("[%s] etcd session expired, leadership lost", e.identity)
+	case <-ctx.Done():
+	}
+
+	cancel()
+	e.setLeading(false)
+	e.logger.Infof("[%s] stopped leading", e.identity)
+
+	resignCtx, cancelResign := context.WithTimeout(context.Background(), e.config.RenewDeadline*time.Second)
+	defer cancelResign()
+
+	if err := election.Resign(resignCtx); err != nil {
+		e.logger.Errorf("an error occurred while resigning: %s", err)
+	}
+}
+
+func (e *Election) setLeading(leading bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.leading = leading
+	if leading {
+		promeIsLeader.Set(1)
+	} else {
+		promeIsLeader.Set(0)
+	}
+}
+
+func (e *Election) setLeader(identity string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.leader = identity
+}
+
+// IsLeader returns true when this replica runs the controllers
+func (e *Election) IsLeader() bool {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	return e.leading
+}
+
+// State describes the leadership as seen by this replica
+type State struct {
+	Enable   bool   `json:"enable"`
+	Backend  string `json:"backend"`
+	Identity string `json:"identity"`
+	Leader   string `json:"leader"`
+	IsLeader bool   `json:"is_leader"`
+}
+
+func (e *Election) State() State {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	return State{
+		Enable:   e.config.Enable,
+		Backend:  e.config.Backend,
+		Identity: e.identity,
+		Leader:   e.leader,
+		IsLeader: e.leading,
+	}
+}
@@ -0,0 +1,13 @@
+package election
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/srelab/watcher/pkg/handlers/shared"
+)
+
+// Returns the leadership state of this replica
+func (e *Election) GetState(ctx echo.Context) error {
+	return shared.Responder{Status: http.StatusOK, Success: true, Result: e.State()}.JSON(ctx)
+}
@@ -2,24 +2,35 @@ package g
 
 import (
 	"fmt"
+	"path"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/go-playground/validator"
 
 	"github.com/srelab/common/log"
 
 	"github.com/spf13/viper"
 	"github.com/urfave/cli"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 // Configuration are the available config values
 type Configuration struct {
 	Log        log.Config  `mapstructure:"Log"`
 	Http       *Http       `mapstructure:"Http"`
-	Resource   *Resource   `mapstructure:"Resource"`
+	Resource   []Resource  `mapstructure:"Resource"`
 	Kubernetes *Kubernetes `mapstructure:"Kubernetes"`
 	Handlers   *Handlers   `mapstructure:"Handlers"`
+
+	LeaderElection *LeaderElection `mapstructure:"LeaderElection"`
+
+	// seconds given to the handlers to process the queued events on SIGTERM,
+	// the events left are saved to the dlq handler and replayed at the next start
+	ShutdownTimeout time.Duration `mapstructure:"ShutdownTimeout"`
 }
 
 type Http struct {
@@ -31,18 +42,190 @@ type Http struct {
 type Kubernetes struct {
 	Config string `mapstructure:"Config"`
 
-	// for watching specific namespace, leave it empty for watching all.
-	// this config is ignored when watching namespaces
+	// Clusters to watch, each one runs its own controllers.
+	// when empty, the cluster described by Config is watched as the "default" cluster
+	Clusters []Cluster `mapstructure:"Clusters"`
+
+	// namespaces to watch, leave it empty for watching all, globs like team-* are accepted.
+	// ExcludeNamespace is applied after Namespace, both are ignored for cluster-scoped resources, e.g. namespaces
+	Namespace        []string `mapstructure:"Namespace"`
+	ExcludeNamespace []string `mapstructure:"ExcludeNamespace"`
+
+	Coalesce CoalesceConfig `mapstructure:"Coalesce"`
+
+	// JSON pointers of the fields whose changes are ignored, keyed by the kind, "*" for every kind.
+	// An update only changing ignored fields is not dispatched, "*" tokens match any token
+	IgnorePaths map[string][]string `mapstructure:"IgnorePaths"`
+
+	Owners OwnersConfig `mapstructure:"Owners"`
+
+	Events KubeEvents `mapstructure:"Events"`
+}
+
+// IgnoredPaths returns the ignored paths of the kind, the keys are case-insensitive
+func (k *Kubernetes) IgnoredPaths(kind string) []string {
+	paths := make([]string, 0)
+	for key, keyPaths := range k.IgnorePaths {
+		if key == "*" || strings.EqualFold(key, kind) {
+			paths = append(paths, keyPaths...)
+		}
+	}
+
+	return paths
+}
+
+// The update events of an object received within Window seconds are coalesced into one event,
+// from the state before the first update to the state after the last one. 0 disables it.
+// Resources are the kinds whose updates are coalesced, empty for all
+type CoalesceConfig struct {
+	Window    time.Duration `mapstructure:"Window"`
+	Resources []string      `mapstructure:"Resources"`
+}
+
+// The owner chain of the objects (e.g. ReplicaSet then Deployment for a pod) is added to the events,
+// the owners are read from the cluster and cached for CacheTTL seconds. Labels and Annotations are
+// copied to the events from the object, or from its closest owner having them
+type OwnersConfig struct {
+	Enable      bool          `mapstructure:"Enable"`
+	CacheTTL    time.Duration `mapstructure:"CacheTTL"`
+	Labels      []string      `mapstructure:"Labels"`
+	Annotations []string      `mapstructure:"Annotations"`
+}
+
+// The Kubernetes Events (core/v1 events watched as a resource) are dispatched when their type and reason
+// are selected, empty Types or Reasons means all. The events of an involved object with the same reason
+// are dispatched once within DedupeWindow seconds, 0 disables it
+type KubeEvents struct {
+	Types          []string      `mapstructure:"Types"`
+	Reasons        []string      `mapstructure:"Reasons"`
+	ExcludeReasons []string      `mapstructure:"ExcludeReasons"`
+	DedupeWindow   time.Duration `mapstructure:"DedupeWindow"`
+}
+
+// Selects returns true when the type and the reason of an event are selected, case-insensitively
+func (k *KubeEvents) Selects(eventType, reason string) bool {
+	contains := func(values []string, value string) bool {
+		for _, v := range values {
+			if strings.EqualFold(v, value) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if len(k.Types) > 0 && !contains(k.Types, eventType) {
+		return false
+	}
+
+	if len(k.Reasons) > 0 && !contains(k.Reasons, reason) {
+		return false
+	}
+
+	return !contains(k.ExcludeReasons, reason)
+}
+
+// Watches returns true when the namespace is selected by Namespace and ExcludeNamespace
+func (k *Kubernetes) Watches(namespace string) bool {
+	namespaces := k.namespaces()
+	if len(namespaces) > 0 && !matchGlob(namespaces, namespace) {
+		return false
+	}
+
+	return !matchGlob(k.ExcludeNamespace, namespace)
+}
+
+// InformerNamespaces returns the namespace of each informer of a namespaced resource.
+// Every listed namespace has its own informer, unless the list has globs or more than
+// MaxNamespaceInformers namespaces, then a single cluster-wide informer ("") is used
+// and the events of the other namespaces are dropped according to Watches
+func (k *Kubernetes) InformerNamespaces() []string {
+	namespaces := k.namespaces()
+	if len(namespaces) == 0 || len(namespaces) > MaxNamespaceInformers {
+		return []string{metaV1.NamespaceAll}
+	}
+
+	informerNamespaces := make([]string, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		if strings.ContainsAny(namespace, "*?[") {
+			return []string{metaV1.NamespaceAll}
+		}
+
+		if !matchGlob(k.ExcludeNamespace, namespace) {
+			informerNamespaces = append(informerNamespaces, namespace)
+		}
+	}
+
+	return informerNamespaces
+}
+
+// The single namespace of the previous config format is decoded as a list of one, which may be empty
+func (k *Kubernetes) namespaces() []string {
+	namespaces := make([]string, 0, len(k.Namespace))
+	for _, namespace := range k.Namespace {
+		if namespace != "" {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+
+	return namespaces
+}
+
+func matchGlob(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+type Cluster struct {
+	// unique name, carried by the events and used in the routes of the k8s handler
+	Name string `mapstructure:"Name"`
+
+	// path of the kubeconfig file and the context in it, empty for the current context
+	Config  string `mapstructure:"Config"`
+	Context string `mapstructure:"Context"`
+
+	// use the service account of the pod instead of a kubeconfig file
+	InCluster bool `mapstructure:"InCluster"`
+}
+
+// Only the elected replica runs the controllers, the HTTP API is served by every replica
+type LeaderElection struct {
+	Enable bool `mapstructure:"Enable"`
+
+	// lease or etcd, the etcd backend uses the client of the etcd handler
+	Backend string `mapstructure:"Backend"`
+
+	// namespace and name of the Lease object, the name is also used as the etcd election key
 	Namespace string `mapstructure:"Namespace"`
+	Name      string `mapstructure:"Name"`
+
+	// defaults to the hostname, which is the pod name inside of cluster
+	Identity string `mapstructure:"Identity"`
+
+	// in seconds
+	LeaseDuration time.Duration `mapstructure:"LeaseDuration"`
+	RenewDeadline time.Duration `mapstructure:"RenewDeadline"`
+	RetryPeriod   time.Duration `mapstructure:"RetryPeriod"`
 }
 
 type GatewayConfig struct {
 	Namespace string `mapstructure:"Namespace"`
 
+	// the cluster of the namespace, empty for any cluster
+	Cluster string `mapstructure:"Cluster"`
+
 	Host     string `mapstructure:"Host"`
 	Port     string `mapstructure:"Port"`
 	Username string `mapstructure:"Username"`
 	Password string `mapstructure:"Password"`
+
+	// the orphaned servers deleted at most by a reconciliation, 0 for no limit
+	MaxDeletions int `mapstructure:"MaxDeletions"`
 }
 
 type EtcdConfig struct {
@@ -52,6 +235,19 @@ type EtcdConfig struct {
 	Timeout   time.Duration `mapstructure:"Timeout"`
 	DNSPrefix string        `mapstructure:"DNSPrefix"`
 	Endpoints []string      `mapstructure:"Endpoints"`
+
+	// clusters whose pods are registered to CoreDNS, empty for all clusters
+	Clusters []string `mapstructure:"Clusters"`
+
+	Drift DriftConfig `mapstructure:"Drift"`
+}
+
+// The records under DNSPrefix are compared with the pod IPs every Interval seconds, 0 disables it.
+// With AutoFix the drift is fixed, deleting at most MaxDeletions orphaned records per run, 0 for no limit
+type DriftConfig struct {
+	Interval     time.Duration `mapstructure:"Interval"`
+	AutoFix      bool          `mapstructure:"AutoFix"`
+	MaxDeletions int           `mapstructure:"MaxDeletions"`
 }
 
 type SAConfig struct {
@@ -70,31 +266,142 @@ type HarborConfig struct {
 	Password string `mapstructure:"Password"`
 }
 
+// The events given up by the handlers are stored as files in Path
+type DLQConfig struct {
+	Path string `mapstructure:"Path"`
+
+	// the events left at the shutdown are replayed once leading again, the other entries are kept
+	ReplayOnStart bool `mapstructure:"ReplayOnStart"`
+}
+
+// FilterConfig selects the events by the resource types (kinds), namespaces and actions,
+// an empty list matches any event. Namespaces and ExcludeNamespaces accept globs, e.g. prod-*.
+// Labels and Annotations are selectors in the kubectl syntax, e.g. watcher.io/ignore!=true
+type FilterConfig struct {
+	Resources         []string `mapstructure:"Resources"`
+	Namespaces        []string `mapstructure:"Namespaces"`
+	ExcludeNamespaces []string `mapstructure:"ExcludeNamespaces"`
+	Labels            string   `mapstructure:"Labels"`
+	Annotations       string   `mapstructure:"Annotations"`
+	Actions           []string `mapstructure:"Actions"`
+}
+
+// SinkConfig sends the events to an external system, each sink is a handler named sink/<Name>
+// Type: webhook or file
+// Format: json or cloudevents (CloudEvents 1.0 structured JSON)
+type SinkConfig struct {
+	Name   string       `mapstructure:"Name"`
+	Type   string       `mapstructure:"Type"`
+	Format string       `mapstructure:"Format"`
+	Filter FilterConfig `mapstructure:"Filter"`
+
+	// webhook: the body is signed with HMAC-SHA256 when Secret is set, Timeout is in seconds
+	URL     string        `mapstructure:"URL"`
+	Secret  string        `mapstructure:"Secret"`
+	Retries int           `mapstructure:"Retries"`
+	Timeout time.Duration `mapstructure:"Timeout"`
+
+	// file: one event per line, rotated at MaxSize megabytes, the rotated files are kept for MaxAge days
+	Path       string `mapstructure:"Path"`
+	MaxSize    int    `mapstructure:"MaxSize"`
+	MaxBackups int    `mapstructure:"MaxBackups"`
+	MaxAge     int    `mapstructure:"MaxAge"`
+	Compress   bool   `mapstructure:"Compress"`
+}
+
+// ExecConfig runs the command of every rule matching the event, at most Concurrency commands
+// at the same time. Timeout is in seconds, the latest History results are kept for the results API
+type ExecConfig struct {
+	Concurrency int           `mapstructure:"Concurrency"`
+	Timeout     time.Duration `mapstructure:"Timeout"`
+	History     int           `mapstructure:"History"`
+	Rules       []ExecRule    `mapstructure:"Rules"`
+}
+
+// ExecRule runs Command (the program and its arguments, not a shell line) with the event
+// encoded in Format (json or cloudevents) on stdin, Timeout overrides the one of ExecConfig
+type ExecRule struct {
+	Name    string            `mapstructure:"Name"`
+	Filter  FilterConfig      `mapstructure:"Filter"`
+	Command []string          `mapstructure:"Command"`
+	Dir     string            `mapstructure:"Dir"`
+	Env     map[string]string `mapstructure:"Env"`
+	Format  string            `mapstructure:"Format"`
+	Timeout time.Duration     `mapstructure:"Timeout"`
+}
+
+// RuleConfig routes the events matched by Expression to Handlers (handler or sink names, e.g. sink/audit).
+// A handler targeted by rules only receives the events matched by any of them, besides its filter.
+// Expression is in the expr language over event (Cluster, ResourceType, Action, Namespace, Key),
+// object and oldObject, e.g. event.ResourceType == "Pod" && object.status.phase == "Failed"
+type RuleConfig struct {
+	Name       string   `mapstructure:"Name"`
+	Expression string   `mapstructure:"Expression"`
+	Handlers   []string `mapstructure:"Handlers"`
+}
+
+// The latest Size events are kept in memory for the events API
+// Origins are the origins allowed to open the WebSocket stream, empty allows any origin,
+// including the clients which send no Origin header
+type EventsConfig struct {
+	Size    int      `mapstructure:"Size"`
+	Origins []string `mapstructure:"Origins"`
+}
+
+// Every handler has its own queue, processed by Count workers.
+// Timeout limits each call of the handler, in seconds
+type WorkerConfig struct {
+	Count   int           `mapstructure:"Count"`
+	Timeout time.Duration `mapstructure:"Timeout"`
+}
+
 type Handlers struct {
+	// names of the handlers to run, empty for all the handlers, a handler requires its dependencies
+	// to be enabled as well. An optional handler is disabled when its initialization fails,
+	// with the optional handlers depending on it, instead of stopping the watcher
+	Enabled  []string `mapstructure:"Enabled"`
+	Optional []string `mapstructure:"Optional"`
+
 	GatewayConfigs []GatewayConfig `mapstructure:"Gateway"`
 	EtcdConfig     *EtcdConfig     `mapstructure:"Etcd"`
 	SAConfig       *SAConfig       `mapstructure:"SA"`
 	HarborConfig   *HarborConfig   `mapstructure:"Harbor"`
+	DLQConfig      *DLQConfig      `mapstructure:"DLQ"`
+	EventsConfig   *EventsConfig   `mapstructure:"Events"`
+	SinkConfigs    []SinkConfig    `mapstructure:"Sinks"`
+	ExecConfig     *ExecConfig     `mapstructure:"Exec"`
+	Rules          []RuleConfig    `mapstructure:"Rules"`
+
+	// keyed by the handler name, "default" applies to the handlers not listed.
+	// The keys are lowercased by viper, they are matched case-insensitively
+	Workers map[string]WorkerConfig `mapstructure:"Workers"`
+
+	// keyed by the handler name, case-insensitive, the handler only receives the matched events
+	Filters map[string]FilterConfig `mapstructure:"Filters"`
 }
 
+// Resource describes a group/version/resource to be watched, e.g. apps/v1 deployments.
+// Any resource served by the apiserver can be listed here, including custom resources.
+// Group is empty for the core group
 type Resource struct {
-	Deployment            bool `mapstructure:"Deployment"`
-	ReplicationController bool `mapstructure:"ReplicationController"`
-	ReplicaSet            bool `mapstructure:"ReplicaSet"`
-	DaemonSet             bool `mapstructure:"DaemonSet"`
-	Services              bool `mapstructure:"Services"`
-	Pod                   bool `mapstructure:"Pod"`
-	Job                   bool `mapstructure:"Job"`
-	PersistentVolume      bool `mapstructure:"PersistentVolume"`
-	Namespace             bool `mapstructure:"Namespace"`
-	Secret                bool `mapstructure:"Secret"`
-	ConfigMap             bool `mapstructure:"ConfigMap"`
-	Ingress               bool `mapstructure:"Ingress"`
+	Group    string `mapstructure:"Group"`
+	Version  string `mapstructure:"Version"`
+	Resource string `mapstructure:"Resource"`
 }
 
 // Config contains the default values
 var (
-	config = &Configuration{
+	config = defaultConfig()
+
+	lock = new(sync.RWMutex)
+
+	// the config file is read by a new viper on every Load, the global viper only watches it
+	configFile string
+)
+
+// Returns a new configuration with the default values, the config file is decoded into it
+func defaultConfig() *Configuration {
+	return &Configuration{
 		Log: log.Config{
 			Level: "info",
 		},
@@ -105,42 +412,121 @@ var (
 		},
 
 		Kubernetes: &Kubernetes{
-			Config: "",
+			Config:           "",
+			Clusters:         []Cluster{},
+			Namespace:        []string{},
+			ExcludeNamespace: []string{},
+			IgnorePaths: map[string][]string{
+				"*": {"/metadata/resourceVersion", "/metadata/managedFields"},
+			},
+			Owners: OwnersConfig{
+				Enable:      true,
+				CacheTTL:    60,
+				Labels:      []string{"team", "owner"},
+				Annotations: []string{},
+			},
+			Events: KubeEvents{
+				Types:          []string{"Warning"},
+				Reasons:        []string{},
+				ExcludeReasons: []string{},
+				DedupeWindow:   600,
+			},
 		},
 
-		Resource: &Resource{
-			Deployment:            false,
-			ReplicationController: false,
-			ReplicaSet:            false,
-			DaemonSet:             false,
-			Services:              false,
-			Pod:                   false,
-			Job:                   false,
-			PersistentVolume:      false,
-			Namespace:             false,
-			Secret:                false,
-			ConfigMap:             false,
-			Ingress:               false,
-		},
+		Resource: []Resource{},
 
 		Handlers: &Handlers{
 			GatewayConfigs: []GatewayConfig{},
 			SAConfig:       &SAConfig{},
+			DLQConfig:      &DLQConfig{Path: "./dlq", ReplayOnStart: true},
+			EventsConfig:   &EventsConfig{Size: 1000, Origins: []string{}},
+			SinkConfigs:    []SinkConfig{},
+			ExecConfig:     &ExecConfig{Concurrency: 4, Timeout: 30, History: 100, Rules: []ExecRule{}},
 		},
-	}
 
-	lock = new(sync.RWMutex)
-)
+		LeaderElection: &LeaderElection{
+			Enable:        false,
+			Backend:       "lease",
+			Namespace:     "default",
+			Name:          "watcher",
+			LeaseDuration: 15,
+			RenewDeadline: 10,
+			RetryPeriod:   2,
+		},
+
+		ShutdownTimeout: 30,
+	}
+}
 
 func ReadInConfig(ctx *cli.Context) error {
-	viper.SetConfigFile(ctx.String("config_file"))
+	configFile = ctx.String("config_file")
+	viper.SetConfigFile(configFile)
 
-	if err := viper.ReadInConfig(); err != nil {
+	c, err := Load()
+	if err != nil {
 		return fmt.Errorf("Fatal error config file: %s \n", err)
 	}
 
-	if err := viper.Unmarshal(&config); err != nil {
-		return fmt.Errorf("Fatal error config file: %s \n", err)
+	SetConfig(c)
+	return nil
+}
+
+// Load reads and validates the config file again, the configuration in the memory is not changed.
+// The file is read by its own viper, the watcher goroutine of the global viper reads it concurrently
+func Load() (*Configuration, error) {
+	v := viper.New()
+	v.SetConfigFile(configFile)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+
+	c := defaultConfig()
+	if err := v.Unmarshal(&c); err != nil {
+		return nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// SetConfig replaces the configuration in the memory, the callers of Config
+// keep the configuration they have got, so every reload is applied as a whole
+func SetConfig(c *Configuration) {
+	lock.Lock()
+	defer lock.Unlock()
+
+	config = c
+}
+
+// WatchConfig calls onChange whenever the config file is written
+func WatchConfig(onChange func()) {
+	viper.OnConfigChange(func(fsnotify.Event) { onChange() })
+	viper.WatchConfig()
+}
+
+// Validate checks the values that would break the watcher at runtime
+func (c *Configuration) Validate() error {
+	for _, pattern := range append(c.Kubernetes.Namespace, c.Kubernetes.ExcludeNamespace...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("namespace pattern[%s]: %s", pattern, err)
+		}
+	}
+
+	for _, resource := range c.Resource {
+		if resource.Version == "" || resource.Resource == "" {
+			return fmt.Errorf("resource[%s] requires the version and the resource", resource.GroupVersionResource().String())
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, sink := range c.Handlers.SinkConfigs {
+		if sink.Name == "" || names[sink.Name] {
+			return fmt.Errorf("sink name[%s] is empty or duplicated", sink.Name)
+		}
+		names[sink.Name] = true
 	}
 
 	return nil
@@ -154,6 +540,45 @@ func Config() *Configuration {
 	return config
 }
 
+// Returns the worker config of the handler, unset values fall back to the default
+func (h *Handlers) GetWorkerConfig(name string) WorkerConfig {
+	config := WorkerConfig{Count: 1, Timeout: 30}
+	for _, handler := range []string{DefaultWorkers, name} {
+		for key, workerConfig := range h.Workers {
+			if !strings.EqualFold(key, handler) {
+				continue
+			}
+
+			if workerConfig.Count > 0 {
+				config.Count = workerConfig.Count
+			}
+
+			if workerConfig.Timeout > 0 {
+				config.Timeout = workerConfig.Timeout
+			}
+		}
+	}
+
+	return config
+}
+
+// Returns the filter config of the handler, the empty filter matches any event.
+// The reconciliations of the handlers use it as well, so they skip the pods the dispatcher filters out
+func (h *Handlers) GetFilterConfig(name string) FilterConfig {
+	for key, filterConfig := range h.Filters {
+		if strings.EqualFold(key, name) {
+			return filterConfig
+		}
+	}
+
+	return FilterConfig{}
+}
+
+// Returns the GroupVersionResource used by the dynamic informers
+func (r Resource) GroupVersionResource() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: r.Group, Version: r.Version, Resource: r.Resource}
+}
+
 func (h *Http) GetListenAddr() string {
 	if err := validator.New().Struct(h); err != nil {
 		return "0.0.0.0:9999"
@@ -5,4 +5,13 @@ const (
 	AUTHOR  = "freedie.liu"
 	MAIL    = "freedie.liu@wolaidai.com"
 	VERSION = "2.2"
+
+	// name of the cluster when no cluster is configured
+	DefaultCluster = "default"
+
+	// key of the worker config applied to the handlers not listed
+	DefaultWorkers = "default"
+
+	// above this number of watched namespaces, a cluster-wide informer is cheaper than one per namespace
+	MaxNamespaceInformers = 10
 )
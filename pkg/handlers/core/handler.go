@@ -1,6 +1,7 @@
 package core
 
 import (
+	"context"
 	"github.com/srelab/common/log"
 	"github.com/srelab/watcher/pkg/g"
 	"github.com/srelab/watcher/pkg/handlers/etcd"
@@ -8,6 +9,10 @@ import (
 	"github.com/srelab/watcher/pkg/handlers/shared"
 )
 
+func init() {
+	shared.Register("core", func() shared.Handler { return new(Handler) }, "etcd", "gateway")
+}
+
 type Handler struct {
 	handlers struct {
 		// The core handler needs to use the handler for etcd and gateway
@@ -17,12 +22,12 @@ type Handler struct {
 	logger log.Logger
 }
 
-func (h *Handler) Name() string            { return "core" }
-func (h *Handler) RoutePrefix() string     { return "/" + h.Name() }
-func (h *Handler) Close()                  {}
-func (h *Handler) Created(e *shared.Event) {}
-func (h *Handler) Deleted(e *shared.Event) {}
-func (h *Handler) Updated(e *shared.Event) {}
+func (h *Handler) Name() string                                       { return "core" }
+func (h *Handler) RoutePrefix() string                                { return "/" + h.Name() }
+func (h *Handler) Close()                                             {}
+func (h *Handler) Created(ctx context.Context, e *shared.Event) error { return nil }
+func (h *Handler) Deleted(ctx context.Context, e *shared.Event) error { return nil }
+func (h *Handler) Updated(ctx context.Context, e *shared.Event) error { return nil }
 
 // Initialize log and dependent handler
 func (h *Handler) Init(config *g.Configuration, itfs ...interface{}) error {
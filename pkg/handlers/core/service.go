@@ -12,12 +12,12 @@ import (
 func (h *Handler) createService(ctx echo.Context) error {
 	p := ctx.Get("payload").(*shared.ServicePayload)
 
-	if err := h.handlers.etcd.CreateService(p); err != nil {
+	if err := h.handlers.etcd.CreateService(ctx.Request().Context(), p); err != nil {
 		h.logger.Error(err)
 		return shared.Responder{Status: http.StatusInternalServerError, Success: false, Msg: err}.JSON(ctx)
 	}
 
-	if err := h.handlers.gateway.CreateService(p); err != nil {
+	if err := h.handlers.gateway.CreateService(ctx.Request().Context(), p); err != nil {
 		h.logger.Error(err)
 		return shared.Responder{Status: http.StatusInternalServerError, Success: false, Msg: err}.JSON(ctx)
 	}
@@ -29,12 +29,12 @@ func (h *Handler) createService(ctx echo.Context) error {
 func (h *Handler) deleteService(ctx echo.Context) error {
 	p := ctx.Get("payload").(*shared.ServicePayload)
 
-	if err := h.handlers.etcd.DeleteService(p); err != nil {
+	if err := h.handlers.etcd.DeleteService(ctx.Request().Context(), p); err != nil {
 		h.logger.Error(err)
 		return shared.Responder{Status: http.StatusInternalServerError, Success: false, Msg: err}.JSON(ctx)
 	}
 
-	if err := h.handlers.gateway.DeleteService(p); err != nil {
+	if err := h.handlers.gateway.DeleteService(ctx.Request().Context(), p); err != nil {
 		h.logger.Error(err)
 		return shared.Responder{Status: http.StatusInternalServerError, Success: false, Msg: err}.JSON(ctx)
 	}
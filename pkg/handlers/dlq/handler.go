@@ -0,0 +1,148 @@
+package dlq
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/srelab/common/log"
+	"github.com/srelab/watcher/pkg/controller"
+	"github.com/srelab/watcher/pkg/g"
+	"github.com/srelab/watcher/pkg/handlers/shared"
+)
+
+func init() {
+	shared.Register("dlq", func() shared.Handler { return new(Handler) })
+}
+
+// prometheus collector
+var promeEntries = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: strings.ToLower(g.NAME),
+	Subsystem: "dlq",
+	Name:      "entries",
+	Help:      "Number of the events given up by each handler and kept in the dead letter queue.",
+}, []string{"handler"})
+
+func init() {
+	prometheus.MustRegister(promeEntries)
+}
+
+// Handler keeps the events given up by the other handlers, so they can be inspected
+// and replayed to the same handler once the cause of the failure has been fixed
+type Handler struct {
+	config     *g.DLQConfig
+	store      *Store
+	dispatcher *controller.Dispatcher
+	logger     log.Logger
+}
+
+func (h *Handler) Name() string        { return "dlq" }
+func (h *Handler) RoutePrefix() string { return "/" + h.Name() }
+func (h *Handler) Close()              {}
+
+// The dlq handler needs the dispatcher to receive the given up events and to replay them
+func (h *Handler) Init(config *g.Configuration, itfs ...interface{}) error {
+	h.config = config.Handlers.DLQConfig
+	h.logger = log.With("handlers", h.Name())
+
+	for _, itf := range itfs {
+		switch object := itf.(type) {
+		case *controller.Dispatcher:
+			h.dispatcher = object
+		}
+	}
+
+	if h.dispatcher == nil {
+		return errors.New("dispatcher does not exist")
+	}
+
+	store, err := NewStore(h.config.Path)
+	if err != nil {
+		return err
+	}
+	h.store = store
+
+	entries, err := h.store.List()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		promeEntries.WithLabelValues(entry.Handler).Inc()
+	}
+
+	h.logger.Infof("%d entries loaded from %s", len(entries), h.config.Path)
+	h.dispatcher.SetDeadLetter(h)
+	return nil
+}
+
+func (h *Handler) Created(ctx context.Context, e *shared.Event) error { return nil }
+func (h *Handler) Deleted(ctx context.Context, e *shared.Event) error { return nil }
+func (h *Handler) Updated(ctx context.Context, e *shared.Event) error { return nil }
+
+// Reconcile implements shared.Reconciler, the events left at the last shutdown are replayed
+// once leading, since the events of the controllers are only dispatched by the leader
+func (h *Handler) Reconcile(ctx context.Context, lister shared.Lister) error {
+	if !h.config.ReplayOnStart {
+		return nil
+	}
+
+	entries, err := h.store.List()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.Shutdown {
+			continue
+		}
+
+		if err := h.Replay(entry); err != nil {
+			h.logger.Errorf("replay entry[%s] error: %s", entry.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// Put saves the event given up by the handler
+func (h *Handler) Put(event *shared.Event, handler string, attempts int, err error) error {
+	entry, newErr := NewEntry(event, handler, attempts, err)
+	if newErr != nil {
+		return newErr
+	}
+
+	if err := h.store.Put(entry); err != nil {
+		return err
+	}
+
+	promeEntries.WithLabelValues(handler).Inc()
+	h.logger.Warnf("event %s of handler[%s] saved as entry[%s]", event.Key, handler, entry.ID)
+	return nil
+}
+
+// Replay gives the event of the entry to its handler again and removes the entry,
+// the event comes back as a new entry if the handler gives it up again
+func (h *Handler) Replay(entry *Entry) error {
+	event, err := entry.Event()
+	if err != nil {
+		return err
+	}
+
+	if err := h.dispatcher.Replay(entry.Handler, event); err != nil {
+		return err
+	}
+
+	return h.Discard(entry)
+}
+
+// Discard removes the entry without replaying it
+func (h *Handler) Discard(entry *Entry) error {
+	if err := h.store.Delete(entry.ID); err != nil {
+		return err
+	}
+
+	promeEntries.WithLabelValues(entry.Handler).Dec()
+	return nil
+}
@@ -0,0 +1,92 @@
+package dlq
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/srelab/watcher/pkg/handlers/shared"
+)
+
+func (h *Handler) AddRoutes(group *echo.Group) {
+	group.GET(shared.EmptyPath, h.getName)
+	group.GET("/entries", h.getEntries)
+	group.GET("/entries/:id", h.getEntry)
+	group.POST("/entries/:id/replay", h.replayEntry)
+	group.DELETE("/entries/:id", h.discardEntry)
+}
+
+func (h *Handler) getName(ctx echo.Context) error {
+	return shared.Responder{Status: http.StatusOK, Success: true, Result: h.Name()}.JSON(ctx)
+}
+
+// Get the list of entries, filtered by the handler query parameter.
+// The object snapshots are left out, they are returned by the entry itself
+func (h *Handler) getEntries(ctx echo.Context) error {
+	entries, err := h.store.List()
+	if err != nil {
+		return shared.Responder{Status: http.StatusInternalServerError, Success: false, Msg: err}.JSON(ctx)
+	}
+
+	handler := ctx.QueryParam("handler")
+	results := make([]*Entry, 0)
+	for _, entry := range entries {
+		if handler != "" && entry.Handler != handler {
+			continue
+		}
+
+		entry.Object, entry.OldObject = nil, nil
+		results = append(results, entry)
+	}
+
+	return shared.Responder{Status: http.StatusOK, Success: true, Result: results}.JSON(ctx)
+}
+
+func (h *Handler) getEntry(ctx echo.Context) error {
+	entry, err := h.entry(ctx)
+	if err != nil {
+		return shared.Responder{Status: http.StatusNotFound, Success: false, Msg: err}.JSON(ctx)
+	}
+
+	return shared.Responder{Status: http.StatusOK, Success: true, Result: entry}.JSON(ctx)
+}
+
+func (h *Handler) replayEntry(ctx echo.Context) error {
+	entry, err := h.entry(ctx)
+	if err != nil {
+		return shared.Responder{Status: http.StatusNotFound, Success: false, Msg: err}.JSON(ctx)
+	}
+
+	if err := h.Replay(entry); err != nil {
+		return shared.Responder{Status: http.StatusBadRequest, Success: false, Msg: err}.JSON(ctx)
+	}
+
+	return shared.Responder{Status: http.StatusOK, Success: true, Result: entry.ID}.JSON(ctx)
+}
+
+func (h *Handler) discardEntry(ctx echo.Context) error {
+	entry, err := h.entry(ctx)
+	if err != nil {
+		return shared.Responder{Status: http.StatusNotFound, Success: false, Msg: err}.JSON(ctx)
+	}
+
+	if err := h.Discard(entry); err != nil {
+		return shared.Responder{Status: http.StatusBadRequest, Success: false, Msg: err}.JSON(ctx)
+	}
+
+	return shared.Responder{Status: http.StatusOK, Success: true, Result: entry.ID}.JSON(ctx)
+}
+
+// Returns the entry of the id route param
+func (h *Handler) entry(ctx echo.Context) (*Entry, error) {
+	entry, err := h.store.Get(ctx.Param("id"))
+	if err != nil {
+		return nil, err
+	}
+
+	if entry == nil {
+		return nil, fmt.Errorf("entry[%s] does not exist", ctx.Param("id"))
+	}
+
+	return entry, nil
+}
@@ -0,0 +1,226 @@
+package dlq
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/srelab/watcher/pkg/controller"
+	"github.com/srelab/watcher/pkg/handlers/shared"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Entry is an event given up by a handler, with the snapshot of the object
+type Entry struct {
+	ID           string              `json:"id"`
+	Handler      string              `json:"handler"`
+	Cluster      string              `json:"cluster"`
+	ResourceType shared.ResourceType `json:"resource_type"`
+	Action       string              `json:"action"`
+	Key          string              `json:"key"`
+	Namespace    string              `json:"namespace"`
+	Object       json.RawMessage     `json:"object,omitempty"`
+	OldObject    json.RawMessage     `json:"old_object,omitempty"`
+	Diff         []shared.Change     `json:"diff,omitempty"`
+	Owners       []shared.Owner      `json:"owners,omitempty"`
+	Labels       map[string]string   `json:"labels,omitempty"`
+	Error        string              `json:"error"`
+	Shutdown     bool                `json:"shutdown,omitempty"`
+	Attempts     int                 `json:"attempts"`
+	CreatedAt    *shared.Datetime    `json:"created_at"`
+}
+
+// used to generate unique ids within the same nanosecond
+var sequence uint32
+
+func NewEntry(event *shared.Event, handler string, attempts int, err error) (*Entry, error) {
+	object, marshalErr := json.Marshal(event.Object)
+	if marshalErr != nil {
+		return nil, marshalErr
+	}
+
+	entry := &Entry{
+		ID:           fmt.Sprintf("%d-%d", time.Now().UnixNano(), atomic.AddUint32(&sequence, 1)),
+		Handler:      handler,
+		Cluster:      event.Cluster,
+		ResourceType: event.ResourceType,
+		Action:       event.Action,
+		Key:          event.Key,
+		Namespace:    event.Namespace,
+		Object:       object,
+		Diff:         event.Diff,
+		Owners:       event.Owners,
+		Labels:       event.Labels,
+		Attempts:     attempts,
+		CreatedAt:    &shared.Datetime{Time: time.Now()},
+	}
+
+	if err != nil {
+		entry.Error = err.Error()
+		entry.Shutdown = err == controller.ErrShutdown
+	}
+
+	if event.OldObject != nil {
+		if entry.OldObject, marshalErr = json.Marshal(event.OldObject); marshalErr != nil {
+			return nil, marshalErr
+		}
+	}
+
+	return entry, nil
+}
+
+// Event rebuilds the event from the snapshot, the objects of the built-in types are typed again
+func (e *Entry) Event() (*shared.Event, error) {
+	event := &shared.Event{
+		Key:          e.Key,
+		Action:       e.Action,
+		Namespace:    e.Namespace,
+		ResourceType: e.ResourceType,
+		Cluster:      e.Cluster,
+		Diff:         e.Diff,
+		Owners:       e.Owners,
+		Labels:       e.Labels,
+	}
+
+	var err error
+	if event.Object, err = decodeObject(e.Object); err != nil {
+		return nil, fmt.Errorf("decode object error: %s", err)
+	}
+
+	if event.OldObject, err = decodeObject(e.OldObject); err != nil {
+		return nil, fmt.Errorf("decode old object error: %s", err)
+	}
+
+	return event, nil
+}
+
+func decodeObject(raw json.RawMessage) (interface{}, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+
+	object := new(unstructured.Unstructured)
+	if err := object.UnmarshalJSON(raw); err != nil {
+		return nil, err
+	}
+
+	return controller.Typed(object), nil
+}
+
+// Store keeps every entry as a JSON file in a local directory,
+// so the entries survive restarts and can be inspected by hand
+type Store struct {
+	path string
+	lock sync.RWMutex
+}
+
+func NewStore(path string) (*Store, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+
+	return &Store{path: path}, nil
+}
+
+func (s *Store) filename(id string) string {
+	return filepath.Join(s.path, id+".json")
+}
+
+// Put writes the entry to a temporary file first, so a crash never leaves a partial entry
+func (s *Store) Put(entry *Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	tmp := s.filename(entry.ID) + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.filename(entry.ID))
+}
+
+// Get returns the entry by id, nil when it does not exist
+func (s *Store) Get(id string) (*Entry, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	data, err := ioutil.ReadFile(s.filename(filepath.Base(id)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	entry := new(Entry)
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// List returns all entries, the oldest first
+func (s *Store) List() ([]*Entry, error) {
+	s.lock.RLock()
+	files, err := ioutil.ReadDir(s.path)
+	s.lock.RUnlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0)
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") {
+			ids = append(ids, strings.TrimSuffix(file.Name(), ".json"))
+		}
+	}
+
+	// ids start with the creation time in nanoseconds
+	sort.Slice(ids, func(i, j int) bool {
+		iTime, _ := strconv.ParseInt(strings.SplitN(ids[i], "-", 2)[0], 10, 64)
+		jTime, _ := strconv.ParseInt(strings.SplitN(ids[j], "-", 2)[0], 10, 64)
+		return iTime < jTime
+	})
+
+	entries := make([]*Entry, 0)
+	for _, id := range ids {
+		entry, err := s.Get(id)
+		if err != nil {
+			return nil, fmt.Errorf("entry[%s]: %s", id, err)
+		}
+
+		if entry != nil {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+// Delete removes the entry, it is not an error when the entry does not exist
+func (s *Store) Delete(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := os.Remove(s.filename(filepath.Base(id))); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
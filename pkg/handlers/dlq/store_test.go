@@ -0,0 +1,218 @@
+package dlq
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/srelab/watcher/pkg/controller"
+	"github.com/srelab/watcher/pkg/handlers/shared"
+
+	apiV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestStore(t *testing.T) (*Store, func()) {
+	dir, err := ioutil.TempDir("", "dlq")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewStore(filepath.Join(dir, "entries"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return store, func() { os.RemoveAll(dir) }
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	// the objects of the events are converted from the dynamic informers, they keep their kind
+	typeMeta := metaV1.TypeMeta{APIVersion: "v1", Kind: "Pod"}
+	web := &apiV1.Pod{TypeMeta: typeMeta, ObjectMeta: metaV1.ObjectMeta{Name: "web", Namespace: "default", Labels: map[string]string{"app": "web"}}}
+	api := &apiV1.Pod{TypeMeta: typeMeta, ObjectMeta: metaV1.ObjectMeta{Name: "web", Namespace: "default", Labels: map[string]string{"app": "api"}}}
+
+	tests := []struct {
+		name  string
+		event *shared.Event
+		err   error
+	}{
+		{
+			name:  "create",
+			event: &shared.Event{Key: "default/web", Action: "create", Namespace: "default", ResourceType: shared.ResourceTypePod, Cluster: "east", Object: web},
+			err:   errors.New("connection refused"),
+		},
+		{
+			name: "update with the diff, owners and labels",
+			event: &shared.Event{
+				Key: "default/web", Action: "update", Namespace: "default", ResourceType: shared.ResourceTypePod, Cluster: "east",
+				Object: api, OldObject: web,
+				Diff:   []shared.Change{{Path: "/metadata/labels/app", Old: "web", New: "api"}},
+				Owners: []shared.Owner{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "web-1"}},
+				Labels: map[string]string{"team": "infra"},
+			},
+			err: errors.New("timeout"),
+		},
+		{
+			name:  "shutdown",
+			event: &shared.Event{Key: "default/web", Action: "delete", Namespace: "default", ResourceType: shared.ResourceTypePod, Cluster: "east", Object: web},
+			err:   controller.ErrShutdown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, cleanup := newTestStore(t)
+			defer cleanup()
+
+			entry, err := NewEntry(tt.event, "etcd", 3, tt.err)
+			if err != nil {
+				t.Fatalf("NewEntry() error = %v", err)
+			}
+
+			if err := store.Put(entry); err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+
+			stored, err := store.Get(entry.ID)
+			if err != nil || stored == nil {
+				t.Fatalf("Get() = %v, %v", stored, err)
+			}
+
+			if stored.Handler != "etcd" || stored.Attempts != 3 || stored.Error != tt.err.Error() {
+				t.Errorf("Get() = %+v, want handler etcd, 3 attempts and error %q", stored, tt.err)
+			}
+
+			if stored.Shutdown != (tt.err == controller.ErrShutdown) {
+				t.Errorf("Shutdown = %v, want %v", stored.Shutdown, tt.err == controller.ErrShutdown)
+			}
+
+			event, err := stored.Event()
+			if err != nil {
+				t.Fatalf("Event() error = %v", err)
+			}
+
+			if event.Key != tt.event.Key || event.Action != tt.event.Action || event.Cluster != tt.event.Cluster ||
+				event.Namespace != tt.event.Namespace || event.ResourceType != tt.event.ResourceType {
+				t.Errorf("Event() = %+v, want %+v", event, tt.event)
+			}
+
+			if !reflect.DeepEqual(event.Owners, tt.event.Owners) || !reflect.DeepEqual(event.Labels, tt.event.Labels) {
+				t.Errorf("Event() owners and labels = %v %v, want %v %v", event.Owners, event.Labels, tt.event.Owners, tt.event.Labels)
+			}
+
+			if len(event.Diff) != len(tt.event.Diff) {
+				t.Errorf("Event() diff = %v, want %v", event.Diff, tt.event.Diff)
+			}
+
+			pod, ok := event.Object.(*apiV1.Pod)
+			if !ok {
+				t.Fatalf("Event() object is %T, want *v1.Pod", event.Object)
+			}
+
+			if want := tt.event.Object.(*apiV1.Pod); pod.Name != want.Name || !reflect.DeepEqual(pod.Labels, want.Labels) {
+				t.Errorf("Event() object = %v, want %v", pod.ObjectMeta, want.ObjectMeta)
+			}
+
+			if (event.OldObject == nil) != (tt.event.OldObject == nil) {
+				t.Errorf("Event() old object = %v, want %v", event.OldObject, tt.event.OldObject)
+			}
+		})
+	}
+}
+
+func TestStoreList(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+
+	ids := []string{"300-1", "100-2", "200-3"}
+	for _, id := range ids {
+		if err := store.Put(&Entry{ID: id, Handler: "etcd"}); err != nil {
+			t.Fatalf("Put() error = %v", err)
+		}
+	}
+
+	// the files left by an interrupted Put are not entries
+	if err := ioutil.WriteFile(store.filename("400-4")+".tmp", []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := store.List()
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+
+	got := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		got = append(got, entry.ID)
+	}
+
+	if want := []string{"100-2", "200-3", "300-1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %v, want %v", got, want)
+	}
+}
+
+func TestStorePathSanitising(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+
+	// a file next to the store directory, out of its reach
+	outside := filepath.Join(filepath.Dir(store.path), "outside.json")
+	if err := ioutil.WriteFile(outside, []byte(`{"id":"outside"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Put(&Entry{ID: "100-1", Handler: "etcd"}); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		id     string
+		wantID string
+	}{
+		{name: "id", id: "100-1", wantID: "100-1"},
+		{name: "missing id", id: "200-1"},
+		{name: "parent directory", id: "../outside"},
+		{name: "absolute path", id: outside[:len(outside)-len(".json")]},
+		{name: "path to an entry", id: "../entries/100-1", wantID: "100-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, err := store.Get(tt.id)
+			if err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+
+			gotID := ""
+			if entry != nil {
+				gotID = entry.ID
+			}
+
+			if gotID != tt.wantID {
+				t.Errorf("Get(%q) = %q, want %q", tt.id, gotID, tt.wantID)
+			}
+		})
+	}
+
+	if err := store.Delete("../outside"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("Delete() removed the file out of the store: %v", err)
+	}
+
+	if err := store.Delete("100-1"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+
+	if entry, err := store.Get("100-1"); entry != nil || err != nil {
+		t.Errorf("Get() after Delete() = %v, %v, want nil", entry, err)
+	}
+}
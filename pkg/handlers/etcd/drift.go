@@ -0,0 +1,251 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/srelab/watcher/pkg/g"
+	"github.com/srelab/watcher/pkg/handlers/shared"
+	apiV1 "k8s.io/api/core/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// prometheus collector
+var promeDrift = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: strings.ToLower(g.NAME),
+	Subsystem: "etcd_drift",
+	Name:      "records",
+	Help:      "Number of the DNS records drifted from the pod IPs at the last check, by type.",
+}, []string{"type"})
+
+func init() {
+	prometheus.MustRegister(promeDrift)
+}
+
+// Drift between the records under the DNS prefix and the pods in the informer cache, each slice holds etcd keys
+// Missing: the services of the pods without record
+// Orphaned: the records written by the watcher for the watched clusters and namespaces without pod, e.g. a missed delete
+// Mismatched: the records whose host is not the IP of the pod (e.g. a reused IP), or written without the owner
+// Unmanaged: the records without pod the handler does not own, e.g. written through the keys API
+// or for the pods not selected by the handler, they are only reported
+type Drift struct {
+	Missing    []string         `json:"missing"`
+	Orphaned   []string         `json:"orphaned"`
+	Mismatched []string         `json:"mismatched"`
+	Unmanaged  []string         `json:"unmanaged"`
+	CheckedAt  *shared.Datetime `json:"checked_at"`
+
+	// the services of the pods, keyed by the etcd key
+	services map[string]*shared.ServicePayload
+}
+
+// Len returns the number of the records to fix, the unmanaged records are left alone
+func (d *Drift) Len() int {
+	return len(d.Missing) + len(d.Orphaned) + len(d.Mismatched)
+}
+
+// Drift compares the records under the DNS prefix with the services of the ready pods listed by the lister
+func (h *Handler) Drift(ctx context.Context, lister shared.Lister) (*Drift, error) {
+	if lister == nil {
+		return nil, errors.New("pods cannot be listed")
+	}
+
+	events, err := lister.List(shared.ResourceTypePod)
+	if err != nil {
+		return nil, err
+	}
+
+	drift := &Drift{
+		Missing:    make([]string, 0),
+		Orphaned:   make([]string, 0),
+		Mismatched: make([]string, 0),
+		Unmanaged:  make([]string, 0),
+		CheckedAt:  &shared.Datetime{Time: time.Now()},
+		services:   make(map[string]*shared.ServicePayload),
+	}
+
+	// the services of the pods not selected by the handler, their records are not orphaned
+	excluded := make(map[string]bool)
+
+	filter := h.getFilter()
+	for _, e := range events {
+		pod, ok := e.Object.(*apiV1.Pod)
+		if !ok {
+			continue
+		}
+
+		selected := h.Watches(e.Cluster) && filter.Match(e)
+		if selected && !shared.PodReady(pod) {
+			continue
+		}
+
+		services, err := e.GetPodServices(pod)
+		if err != nil {
+			continue
+		}
+
+		for _, service := range services {
+			if selected {
+				drift.services[h.ServiceKey(service)] = service
+			} else {
+				excluded[h.ServiceKey(service)] = true
+			}
+		}
+	}
+
+	response, err := h.GetKey(ctx, h.DNSPrefix()+"/", false, true, 0)
+	if err != nil {
+		return nil, fmt.Errorf("get key error: %s", err)
+	}
+
+	existing := make(map[string]bool)
+	for _, item := range response.Kvs {
+		key := string(item.Key)
+		existing[key] = true
+
+		record := new(dnsRecord)
+		err := json.Unmarshal(item.Value, record)
+
+		service, ok := drift.services[key]
+		switch {
+		case !ok && (err != nil || excluded[key] || !h.owns(record, filter)):
+			drift.Unmanaged = append(drift.Unmanaged, key)
+		case !ok:
+			drift.Orphaned = append(drift.Orphaned, key)
+		case err != nil || record.Host != service.Host || record.Cluster == "":
+			drift.Mismatched = append(drift.Mismatched, key)
+		}
+	}
+
+	for key := range drift.services {
+		if !existing[key] {
+			drift.Missing = append(drift.Missing, key)
+		}
+	}
+
+	sort.Strings(drift.Missing)
+	sort.Strings(drift.Orphaned)
+	sort.Strings(drift.Mismatched)
+	sort.Strings(drift.Unmanaged)
+
+	return drift, nil
+}
+
+// the record written by ServicePayload.DNSRecord
+type dnsRecord struct {
+	Host      string `json:"host"`
+	Cluster   string `json:"cluster"`
+	Namespace string `json:"namespace"`
+}
+
+// Returns true when the record has been written by the watcher for a cluster and a namespace
+// the handler watches, the records written before the owner was added to the records are not owned
+func (h *Handler) owns(record *dnsRecord, filter *shared.Filter) bool {
+	if record.Cluster == "" || record.Namespace == "" || !h.Watches(record.Cluster) {
+		return false
+	}
+
+	return g.Config().Kubernetes.Watches(record.Namespace) && filter.MatchNamespace(record.Namespace)
+}
+
+// Fix writes the missing and mismatched records and deletes the orphaned records,
+// at most maxDeletions records are deleted when it is greater than 0
+func (h *Handler) Fix(ctx context.Context, drift *Drift, maxDeletions int) error {
+	var (
+		errs           []error
+		fixed, deleted int
+	)
+
+	for _, key := range append(drift.Missing, drift.Mismatched...) {
+		if err := h.CreateService(ctx, drift.services[key]); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		fixed++
+	}
+
+	for _, key := range drift.Orphaned {
+		if maxDeletions > 0 && deleted >= maxDeletions {
+			errs = append(errs, fmt.Errorf(
+				"%d orphaned keys left, the limit of %d deletions is reached", len(drift.Orphaned)-deleted, maxDeletions,
+			))
+			break
+		}
+
+		if _, err := h.DeleteKey(ctx, key, false); err != nil {
+			errs = append(errs, fmt.Errorf("etcd key[%s] cannot be delete: %s", key, err))
+			continue
+		}
+
+		h.logger.Infof("[etcd] - orphaned key[%s] delete successful", key)
+		deleted++
+	}
+
+	h.logger.Infof("[etcd] - %d records written, %d orphaned keys deleted", fixed, deleted)
+	return utilerrors.NewAggregate(errs)
+}
+
+// Reconcile registers the services of the cached pods missing in etcd, and removes the records
+// under the DNS prefix whose pods do not exist anymore, within the Drift.MaxDeletions limit
+func (h *Handler) Reconcile(ctx context.Context, lister shared.Lister) error {
+	drift, err := h.Drift(ctx, lister)
+	if err != nil {
+		return err
+	}
+
+	h.setDrift(drift)
+	return h.Fix(ctx, drift, h.getConfig().Drift.MaxDeletions)
+}
+
+// Scheduled by the Drift.Interval config, only the leader has the pods to compare with
+func (h *Handler) checkDrift() {
+	drift, err := h.Drift(h.ctx, h.lister)
+	if err != nil {
+		h.logger.Debugf("drift detection skipped: %s", err)
+		return
+	}
+
+	h.setDrift(drift)
+	if drift.Len() == 0 {
+		return
+	}
+
+	h.logger.Warnf(
+		"[etcd] - drift detected, %d missing, %d orphaned, %d mismatched",
+		len(drift.Missing), len(drift.Orphaned), len(drift.Mismatched),
+	)
+
+	config := h.getConfig()
+	if !config.Drift.AutoFix {
+		return
+	}
+
+	if err := h.Fix(h.ctx, drift, config.Drift.MaxDeletions); err != nil {
+		h.logger.Errorf("drift fix error: %s", err)
+	}
+}
+
+// Keeps the last drift for the drift API
+func (h *Handler) setDrift(drift *Drift) {
+	h.lock.Lock()
+	h.drift = drift
+	h.lock.Unlock()
+
+	promeDrift.WithLabelValues("missing").Set(float64(len(drift.Missing)))
+	promeDrift.WithLabelValues("orphaned").Set(float64(len(drift.Orphaned)))
+	promeDrift.WithLabelValues("mismatched").Set(float64(len(drift.Mismatched)))
+	promeDrift.WithLabelValues("unmanaged").Set(float64(len(drift.Unmanaged)))
+}
+
+func (h *Handler) getDrift() *Drift {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	return h.drift
+}
@@ -5,55 +5,125 @@ import (
 	"fmt"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/coreos/etcd/pkg/transport"
 	"github.com/pkg/errors"
 	"github.com/srelab/common/log"
+	"github.com/srelab/common/slice"
 	"github.com/srelab/watcher/pkg/g"
 	"github.com/srelab/watcher/pkg/handlers/shared"
 	"go.etcd.io/etcd/clientv3"
 	"go.etcd.io/etcd/etcdserver/api/v3rpc/rpctypes"
 	apiV1 "k8s.io/api/core/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
-type Handler struct {
-	config *g.EtcdConfig
+func init() {
+	shared.Register("etcd", func() shared.Handler { return new(Handler) })
+}
 
+type Handler struct {
 	client *clientv3.Client
+	lister shared.Lister
 	logger log.Logger
+
+	// stops the drift detection
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// the config and the filter are replaced on reloads
+	lock   sync.RWMutex
+	config *g.EtcdConfig
+	filter *shared.Filter
+	drift  *Drift
 }
 
-func (h *Handler) Name() string            { return "etcd" }
-func (h *Handler) Handler() *Handler       { return h }
-func (h *Handler) RoutePrefix() string     { return "/" + h.Name() }
-func (h *Handler) DNSPrefix() string       { return h.config.DNSPrefix }
-func (h *Handler) Close()                  { h.client.Close() }
-func (h *Handler) Created(e *shared.Event) {}
-func (h *Handler) Updated(e *shared.Event) {}
+func (h *Handler) Name() string                                       { return "etcd" }
+func (h *Handler) Handler() *Handler                                  { return h }
+func (h *Handler) RoutePrefix() string                                { return "/" + h.Name() }
+func (h *Handler) DNSPrefix() string                                  { return h.getConfig().DNSPrefix }
+func (h *Handler) Client() *clientv3.Client                           { return h.client }
+func (h *Handler) Created(ctx context.Context, e *shared.Event) error { return h.transition(ctx, e) }
+func (h *Handler) Updated(ctx context.Context, e *shared.Event) error { return h.transition(ctx, e) }
+
+// Write the DNS resolution records when the pod becomes ready, and remove them
+// when the pod becomes NotReady, starts terminating or changes the IP
+func (h *Handler) transition(ctx context.Context, e *shared.Event) error {
+	if !h.Watches(e.Cluster) {
+		return nil
+	}
+
+	register, deregister, err := e.PodTransition()
+	if err != nil {
+		h.logger.Errorf("an error occurred while getting services: %s", err)
+		return nil
+	}
+
+	var errs []error
+	for _, service := range deregister {
+		if err := h.DeleteService(ctx, service); err != nil {
+			errs = append(errs, fmt.Errorf("an error occurred while deleting the service: %s", err))
+		}
+	}
+
+	for _, service := range register {
+		if err := h.CreateService(ctx, service); err != nil {
+			errs = append(errs, fmt.Errorf("an error occurred while creating the service: %s", err))
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
 
 // Remove DNS resolution records from etcd when the pod is detected to be destroyed
-func (h *Handler) Deleted(e *shared.Event) {
+// the services failed to be deleted are returned as an aggregate error, so the event is retried
+func (h *Handler) Deleted(ctx context.Context, e *shared.Event) error {
+	if !h.Watches(e.Cluster) {
+		return nil
+	}
+
 	switch object := e.Object.(type) {
 	case *apiV1.Pod:
 		services, err := e.GetPodServices(object)
 		if err != nil {
 			h.logger.Errorf("an error occurred while getting services: %s", err)
-			return
+			return nil
 		}
 
+		var errs []error
 		for _, service := range services {
-			if err := h.DeleteService(service); err != nil {
-				h.logger.Errorf("an error occurred while deleting the service: %s", err)
+			if err := h.DeleteService(ctx, service); err != nil {
+				errs = append(errs, fmt.Errorf("an error occurred while deleting the service: %s", err))
 			}
 		}
+
+		return utilerrors.NewAggregate(errs)
 	default:
-		return
+		return nil
 	}
 }
 
+// Stop the drift detection and close the etcd client
+func (h *Handler) Close() {
+	h.cancel()
+	h.client.Close()
+}
+
+// Returns true when the pods of the cluster are registered to CoreDNS
+func (h *Handler) Watches(cluster string) bool {
+	config := h.getConfig()
+	return len(config.Clusters) == 0 || slice.ContainsString(config.Clusters, cluster)
+}
+
 // Initialize the Etcd client and log
 func (h *Handler) Init(config *g.Configuration, itfs ...interface{}) error {
+	if config.Handlers.EtcdConfig == nil {
+		return errors.New("etcd config is missing")
+	}
+
 	h.config = config.Handlers.EtcdConfig
 	h.config.DNSPrefix = strings.TrimRight(h.config.DNSPrefix, "/")
 
@@ -86,6 +156,23 @@ func (h *Handler) Init(config *g.Configuration, itfs ...interface{}) error {
 	h.client = client
 	h.logger = log.With("handlers", h.Name())
 
+	if h.filter, err = shared.NewFilter(config.Handlers.GetFilterConfig(h.Name())); err != nil {
+		return err
+	}
+
+	// The pods are listed from the informer cache, which only exists on the leader
+	for _, itf := range itfs {
+		switch object := itf.(type) {
+		case shared.Lister:
+			h.lister = object
+		}
+	}
+
+	h.ctx, h.cancel = context.WithCancel(context.Background())
+	if h.config.Drift.Interval > 0 {
+		go wait.Until(h.checkDrift, h.config.Drift.Interval*time.Second, h.ctx.Done())
+	}
+
 	return nil
 }
 
@@ -94,8 +181,8 @@ func (h *Handler) Init(config *g.Configuration, itfs ...interface{}) error {
 // keysOnly: Return only key, no return value
 // prefix: Match key based on the prefix
 // limit: Limit the number of returns
-func (h *Handler) GetKey(key string, keysOnly, prefix bool, limit int64) (*clientv3.GetResponse, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), h.config.Timeout*time.Second)
+func (h *Handler) GetKey(ctx context.Context, key string, keysOnly, prefix bool, limit int64) (*clientv3.GetResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, h.getConfig().Timeout*time.Second)
 
 	var options []clientv3.OpOption
 	if prefix {
@@ -108,9 +195,12 @@ func (h *Handler) GetKey(key string, keysOnly, prefix bool, limit int64) (*clien
 
 	options = append(options, clientv3.WithLimit(limit))
 
+	start := time.Now()
 	response, err := h.client.Get(ctx, key, options...)
 	cancel()
 
+	observe("get", start, err)
+
 	return response, h.eErrorHandling(err)
 }
 
@@ -118,40 +208,115 @@ func (h *Handler) GetKey(key string, keysOnly, prefix bool, limit int64) (*clien
 // key: Any string that returns empty when the matching key is not queried
 // val: Only accept json string values
 // ttl: key expire
-func (h *Handler) PutKey(key, val string, ttl int64) (*clientv3.PutResponse, error) {
+func (h *Handler) PutKey(ctx context.Context, key, val string, ttl int64) (*clientv3.PutResponse, error) {
+	start := time.Now()
 	if ttl > 0 {
-		ctx, err := h.client.Grant(context.TODO(), ttl)
+		lease, err := h.client.Grant(ctx, ttl)
 		if err != nil {
+			observe("put", start, err)
 			return nil, h.eErrorHandling(err)
 		}
 
-		response, err := h.client.Put(context.TODO(), key, val, clientv3.WithLease(ctx.ID))
+		response, err := h.client.Put(ctx, key, val, clientv3.WithLease(lease.ID))
+		observe("put", start, err)
+
 		return response, h.eErrorHandling(err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), h.config.Timeout*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, h.getConfig().Timeout*time.Second)
 
 	response, err := h.client.Put(ctx, key, val)
 	cancel()
 
+	observe("put", start, err)
+
 	return response, h.eErrorHandling(err)
 }
 
 // Delete Key Val from etcd
-func (h *Handler) DeleteKey(key string, prefix bool) (*clientv3.DeleteResponse, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), h.config.Timeout*time.Second)
+func (h *Handler) DeleteKey(ctx context.Context, key string, prefix bool) (*clientv3.DeleteResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, h.getConfig().Timeout*time.Second)
 
 	var options []clientv3.OpOption
 	if prefix {
 		options = append(options, clientv3.WithPrefix())
 	}
 
+	start := time.Now()
 	response, err := h.client.Delete(ctx, key, options...)
 	cancel()
 
+	observe("delete", start, err)
+
 	return response, h.eErrorHandling(err)
 }
 
+// Reload implements shared.Reloader, the clusters, the filter and the drift fixing are replaced.
+// The client and the drift interval are kept until the next start, as well as the DNS prefix,
+// since the records written under the previous prefix would not be removed anymore
+func (h *Handler) Reload(config *g.Configuration) error {
+	if config.Handlers.EtcdConfig == nil {
+		return errors.New("etcd config is missing")
+	}
+
+	filter, err := shared.NewFilter(config.Handlers.GetFilterConfig(h.Name()))
+	if err != nil {
+		return err
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	reloaded := *h.config
+	reloaded.Clusters = config.Handlers.EtcdConfig.Clusters
+	reloaded.Drift.AutoFix = config.Handlers.EtcdConfig.Drift.AutoFix
+	reloaded.Drift.MaxDeletions = config.Handlers.EtcdConfig.Drift.MaxDeletions
+
+	h.config = &reloaded
+	h.filter = filter
+
+	return nil
+}
+
+func (h *Handler) getConfig() *g.EtcdConfig {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	return h.config
+}
+
+func (h *Handler) getFilter() *shared.Filter {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	return h.filter
+}
+
+// Check implements shared.HealthChecker, the etcd is healthy when any endpoint answers the status call
+func (h *Handler) Check(ctx context.Context) error {
+	var errs []error
+	for _, endpoint := range h.client.Endpoints() {
+		if _, err := h.client.Status(ctx, endpoint); err != nil {
+			errs = append(errs, fmt.Errorf("endpoint[%s]: %s", endpoint, h.eErrorHandling(err)))
+			continue
+		}
+
+		return nil
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// Records the latency of the etcd operation
+func observe(operation string, start time.Time, err error) {
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+
+	shared.ObserveRequest("etcd", operation, status, start)
+}
+
 // Formatting errors returned by etcd
 func (h *Handler) eErrorHandling(err error) error {
 	if err != nil {
@@ -170,9 +335,15 @@ func (h *Handler) eErrorHandling(err error) error {
 	return err
 }
 
+// Returns the etcd key of the DNS record of the service
+func (h *Handler) ServiceKey(service *shared.ServicePayload) string {
+	return filepath.Join(h.DNSPrefix(), service.DNSName(), service.DNSKey())
+}
+
 // Remove DNS resolution records from CoreDNS
-func (h *Handler) DeleteService(service *shared.ServicePayload) error {
+func (h *Handler) DeleteService(ctx context.Context, service *shared.ServicePayload) error {
 	response, err := h.GetKey(
+		ctx,
 		filepath.Join(h.DNSPrefix(), service.DNSName()),
 		false,
 		true,
@@ -184,12 +355,12 @@ func (h *Handler) DeleteService(service *shared.ServicePayload) error {
 	}
 
 	for _, item := range response.Kvs {
-		key := filepath.Join(h.DNSPrefix(), service.DNSName(), service.DNSKey())
+		key := h.ServiceKey(service)
 		if string(item.Key) != key {
 			continue
 		}
 
-		if _, err := h.DeleteKey(key, false); err != nil {
+		if _, err := h.DeleteKey(ctx, key, false); err != nil {
 			return fmt.Errorf("etcd key cannot be delete: %s", err)
 		}
 	}
@@ -199,9 +370,8 @@ func (h *Handler) DeleteService(service *shared.ServicePayload) error {
 }
 
 // Convert service to DNS resolution record and write to etcd for use by CoreDNS
-func (h *Handler) CreateService(service *shared.ServicePayload) error {
-	key := filepath.Join(h.DNSPrefix(), service.DNSName(), service.DNSKey())
-	if _, err := h.PutKey(key, service.DNSRecord(), 0); err != nil {
+func (h *Handler) CreateService(ctx context.Context, service *shared.ServicePayload) error {
+	if _, err := h.PutKey(ctx, h.ServiceKey(service), service.DNSRecord(), 0); err != nil {
 		return fmt.Errorf("etcd key cannot be create: %s", err)
 	}
 
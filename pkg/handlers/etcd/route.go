@@ -54,6 +54,7 @@ func (h *Handler) AddRoutes(group *echo.Group) {
 	group.GET("/keys*", h.getKey)
 	group.PUT("/keys*", h.putKey)
 	group.DELETE("/keys*", h.delKey)
+	group.GET("/drift", h.getDriftReport)
 }
 
 func (h *Handler) getName(ctx echo.Context) error {
@@ -64,7 +65,7 @@ func (h *Handler) getName(ctx echo.Context) error {
 func (h *Handler) getKey(ctx echo.Context) error {
 	p, _ := ctx.Get("payload").(*payload)
 
-	response, err := h.GetKey(p.Key, p.KeysOnly, p.Prefix, p.Limit)
+	response, err := h.GetKey(ctx.Request().Context(), p.Key, p.KeysOnly, p.Prefix, p.Limit)
 	if err != nil {
 		return shared.Responder{Status: http.StatusInternalServerError, Success: false, Msg: err}.JSON(ctx)
 	}
@@ -102,7 +103,7 @@ func (h *Handler) putKey(ctx echo.Context) error {
 	value, _ := json.Marshal(p.Value)
 
 	// store json
-	_, err := h.PutKey(p.Key, string(value), p.Expire)
+	_, err := h.PutKey(ctx.Request().Context(), p.Key, string(value), p.Expire)
 	if err != nil {
 		return shared.Responder{Status: http.StatusInternalServerError, Success: false, Msg: err}.JSON(ctx)
 	}
@@ -114,7 +115,7 @@ func (h *Handler) putKey(ctx echo.Context) error {
 func (h *Handler) delKey(ctx echo.Context) error {
 	p, _ := ctx.Get("payload").(*payload)
 
-	response, err := h.DeleteKey(p.Key, p.Prefix)
+	response, err := h.DeleteKey(ctx.Request().Context(), p.Key, p.Prefix)
 	if err != nil {
 		return shared.Responder{Status: http.StatusInternalServerError, Success: false, Msg: err}.JSON(ctx)
 	}
@@ -122,3 +123,23 @@ func (h *Handler) delKey(ctx echo.Context) error {
 	result := map[string]interface{}{"deleted": response.Deleted}
 	return shared.Responder{Status: http.StatusOK, Success: true, Result: result}.JSON(ctx)
 }
+
+// Get the drift found by the last check, refresh=true checks the drift again
+func (h *Handler) getDriftReport(ctx echo.Context) error {
+	if ctx.QueryParam("refresh") == "true" {
+		drift, err := h.Drift(ctx.Request().Context(), h.lister)
+		if err != nil {
+			return shared.Responder{Status: http.StatusInternalServerError, Success: false, Msg: err}.JSON(ctx)
+		}
+
+		h.setDrift(drift)
+	}
+
+	drift := h.getDrift()
+	if drift == nil {
+		err := "drift has not been checked yet"
+		return shared.Responder{Status: http.StatusNotFound, Success: false, Msg: err}.JSON(ctx)
+	}
+
+	return shared.Responder{Status: http.StatusOK, Success: true, Result: drift}.JSON(ctx)
+}
@@ -0,0 +1,67 @@
+package events
+
+import (
+	"context"
+	"errors"
+
+	"github.com/srelab/common/log"
+	"github.com/srelab/watcher/pkg/controller"
+	"github.com/srelab/watcher/pkg/g"
+	"github.com/srelab/watcher/pkg/handlers/shared"
+)
+
+func init() {
+	shared.Register("events", func() shared.Handler { return new(Handler) })
+}
+
+// Handler keeps the latest events seen by the controllers with the outcome of each handler,
+// and streams the new events to the clients
+type Handler struct {
+	config  *g.EventsConfig
+	history *History
+	logger  log.Logger
+}
+
+func (h *Handler) Name() string        { return "events" }
+func (h *Handler) RoutePrefix() string { return "/" + h.Name() }
+func (h *Handler) Close()              {}
+
+// The events handler observes the dispatcher, instead of receiving the events through its own queue,
+// so the events are recorded in the order they are dispatched
+func (h *Handler) Init(config *g.Configuration, itfs ...interface{}) error {
+	h.config = config.Handlers.EventsConfig
+	h.history = NewHistory(h.config.Size)
+	h.logger = log.With("handlers", h.Name())
+
+	var dispatcher *controller.Dispatcher
+	for _, itf := range itfs {
+		switch object := itf.(type) {
+		case *controller.Dispatcher:
+			dispatcher = object
+		}
+	}
+
+	if dispatcher == nil {
+		return errors.New("dispatcher does not exist")
+	}
+
+	dispatcher.AddObserver(h)
+	return nil
+}
+
+// Passive implements shared.Passive, the events are recorded by Dispatched
+func (h *Handler) Passive() bool { return true }
+
+func (h *Handler) Created(ctx context.Context, e *shared.Event) error { return nil }
+func (h *Handler) Deleted(ctx context.Context, e *shared.Event) error { return nil }
+func (h *Handler) Updated(ctx context.Context, e *shared.Event) error { return nil }
+
+// Dispatched implements controller.Observer
+func (h *Handler) Dispatched(event *shared.Event) {
+	h.history.Add(event)
+}
+
+// Handled implements controller.Observer
+func (h *Handler) Handled(event *shared.Event, handler string, err error) {
+	h.history.SetOutcome(event, handler, err)
+}
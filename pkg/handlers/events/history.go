@@ -0,0 +1,190 @@
+package events
+
+import (
+	"sync"
+	"time"
+
+	"github.com/srelab/watcher/pkg/handlers/shared"
+)
+
+// Record of an event seen by the controllers
+// Handlers: the outcome of the last call of each handler, "ok" or the error
+type Record struct {
+	ID           uint64              `json:"id"`
+	Cluster      string              `json:"cluster"`
+	ResourceType shared.ResourceType `json:"resource_type"`
+	Action       string              `json:"action"`
+	Namespace    string              `json:"namespace"`
+	Key          string              `json:"key"`
+	Time         *shared.Datetime    `json:"time"`
+	Handlers     map[string]string   `json:"handlers"`
+}
+
+// Query filters the records, the empty fields match any record
+type Query struct {
+	Cluster   string           `query:"cluster"`
+	Namespace string           `query:"namespace"`
+	Type      string           `query:"type"`
+	Action    string           `query:"action"`
+	Since     *shared.Datetime `query:"since"`
+	Until     *shared.Datetime `query:"until"`
+	Limit     int              `query:"limit"`
+}
+
+func (q *Query) Match(record *Record) bool {
+	switch {
+	case q.Cluster != "" && q.Cluster != record.Cluster:
+		return false
+	case q.Namespace != "" && q.Namespace != record.Namespace:
+		return false
+	case q.Type != "" && q.Type != string(record.ResourceType):
+		return false
+	case q.Action != "" && q.Action != record.Action:
+		return false
+	case q.Since != nil && !q.Since.IsZero() && record.Time.Before(q.Since.Time):
+		return false
+	case q.Until != nil && !q.Until.IsZero() && record.Time.After(q.Until.Time):
+		return false
+	}
+
+	return true
+}
+
+// History is a ring buffer of the latest records, the records are also published to the subscribers
+type History struct {
+	lock sync.RWMutex
+
+	records []*Record
+	next    int
+	count   int
+	lastID  uint64
+
+	// the records in the buffer, keyed by the event, to update the outcome of the handlers.
+	// keys holds the event of each record in the ring
+	events map[*shared.Event]*Record
+	keys   []*shared.Event
+
+	subscribers map[chan *Record]*Query
+}
+
+func NewHistory(size int) *History {
+	if size <= 0 {
+		size = 1
+	}
+
+	return &History{
+		records:     make([]*Record, size),
+		keys:        make([]*shared.Event, size),
+		events:      make(map[*shared.Event]*Record),
+		subscribers: make(map[chan *Record]*Query),
+	}
+}
+
+// Add appends the record of the event, the oldest record is dropped when the buffer is full
+func (h *History) Add(event *shared.Event) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.lastID++
+	record := &Record{
+		ID:           h.lastID,
+		Cluster:      event.Cluster,
+		ResourceType: event.ResourceType,
+		Action:       event.Action,
+		Namespace:    event.Namespace,
+		Key:          event.Key,
+		Time:         &shared.Datetime{Time: time.Now()},
+		Handlers:     make(map[string]string),
+	}
+
+	if oldest := h.keys[h.next]; oldest != nil && h.events[oldest] == h.records[h.next] {
+		delete(h.events, oldest)
+	}
+
+	h.records[h.next] = record
+	h.keys[h.next] = event
+	h.events[event] = record
+	h.next = (h.next + 1) % len(h.records)
+	if h.count < len(h.records) {
+		h.count++
+	}
+
+	// slow subscribers miss the records instead of blocking the controllers
+	for ch, query := range h.subscribers {
+		if !query.Match(record) {
+			continue
+		}
+
+		select {
+		case ch <- record.copy():
+		default:
+		}
+	}
+}
+
+// SetOutcome records the outcome of the handler, nothing happens when the event is no longer in the buffer
+func (h *History) SetOutcome(event *shared.Event, handler string, err error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	record, ok := h.events[event]
+	if !ok {
+		return
+	}
+
+	if err != nil {
+		record.Handlers[handler] = err.Error()
+	} else {
+		record.Handlers[handler] = "ok"
+	}
+}
+
+// List returns the matched records, the latest first
+func (h *History) List(query *Query) []*Record {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	records := make([]*Record, 0)
+	for i := 1; i <= h.count; i++ {
+		record := h.records[(h.next-i+len(h.records))%len(h.records)]
+		if !query.Match(record) {
+			continue
+		}
+
+		records = append(records, record.copy())
+		if query.Limit > 0 && len(records) >= query.Limit {
+			break
+		}
+	}
+
+	return records
+}
+
+// Subscribe returns the channel of the new matched records, it must be released by Unsubscribe
+func (h *History) Subscribe(query *Query) chan *Record {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	ch := make(chan *Record, 100)
+	h.subscribers[ch] = query
+
+	return ch
+}
+
+func (h *History) Unsubscribe(ch chan *Record) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	delete(h.subscribers, ch)
+}
+
+// the handlers of the record keep changing, callers get a copy
+func (r *Record) copy() *Record {
+	record := *r
+	record.Handlers = make(map[string]string, len(r.Handlers))
+	for handler, outcome := range r.Handlers {
+		record.Handlers[handler] = outcome
+	}
+
+	return &record
+}
@@ -0,0 +1,117 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo"
+	"github.com/srelab/watcher/pkg/handlers/shared"
+	"golang.org/x/net/websocket"
+)
+
+// interval of the comments sent to keep the idle SSE connections alive
+const keepAliveInterval = 30 * time.Second
+
+func (h *Handler) AddRoutes(group *echo.Group) {
+	group.GET(shared.EmptyPath, h.getEvents)
+	group.GET("/stream", h.streamEvents)
+}
+
+// Get the latest events, filtered by Query
+func (h *Handler) getEvents(ctx echo.Context) error {
+	query := new(Query)
+	if err := ctx.Bind(query); err != nil {
+		return shared.Responder{Status: http.StatusBadRequest, Success: false, Msg: err}.JSON(ctx)
+	}
+
+	return shared.Responder{Status: http.StatusOK, Success: true, Result: h.history.List(query)}.JSON(ctx)
+}
+
+// Push the new events filtered by Query, over WebSocket when the connection asks for an upgrade,
+// otherwise as Server-Sent Events
+func (h *Handler) streamEvents(ctx echo.Context) error {
+	query := new(Query)
+	if err := ctx.Bind(query); err != nil {
+		return shared.Responder{Status: http.StatusBadRequest, Success: false, Msg: err}.JSON(ctx)
+	}
+
+	ch := h.history.Subscribe(query)
+	defer h.history.Unsubscribe(ch)
+
+	if strings.EqualFold(ctx.Request().Header.Get(echo.HeaderUpgrade), "websocket") {
+		server := websocket.Server{Handshake: h.checkOrigin, Handler: func(conn *websocket.Conn) {
+			defer conn.Close()
+
+			// the client sends nothing, the read fails when the connection is closed
+			closed := make(chan struct{})
+			go func() {
+				io.Copy(ioutil.Discard, conn)
+				close(closed)
+			}()
+
+			for {
+				select {
+				case <-closed:
+					return
+				case record := <-ch:
+					if err := websocket.JSON.Send(conn, record); err != nil {
+						return
+					}
+				}
+			}
+		}}
+
+		server.ServeHTTP(ctx.Response(), ctx.Request())
+		return nil
+	}
+
+	response := ctx.Response()
+	response.Header().Set(echo.HeaderContentType, "text/event-stream")
+	response.Header().Set("Cache-Control", "no-cache")
+	response.Header().Set("Connection", "keep-alive")
+	response.WriteHeader(http.StatusOK)
+	response.Flush()
+
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Request().Context().Done():
+			return nil
+		case <-ticker.C:
+			fmt.Fprint(response, ": keep-alive\n\n")
+		case record := <-ch:
+			data, err := json.Marshal(record)
+			if err != nil {
+				continue
+			}
+
+			fmt.Fprintf(response, "id: %d\nevent: %s\ndata: %s\n\n", record.ID, record.Action, data)
+		}
+
+		response.Flush()
+	}
+}
+
+// The handshake of websocket.Handler rejects the clients without the Origin header, e.g. the
+// command line clients, so the origin is only checked when the allowed origins are configured
+func (h *Handler) checkOrigin(config *websocket.Config, request *http.Request) error {
+	if len(h.config.Origins) == 0 {
+		return nil
+	}
+
+	origin := request.Header.Get("Origin")
+	for _, allowed := range h.config.Origins {
+		if strings.EqualFold(origin, allowed) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("origin[%s] is not allowed", origin)
+}
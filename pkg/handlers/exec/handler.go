@@ -0,0 +1,223 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	osexec "os/exec"
+	"syscall"
+	"time"
+
+	"github.com/srelab/common/log"
+	"github.com/srelab/watcher/pkg/g"
+	"github.com/srelab/watcher/pkg/handlers/shared"
+	"github.com/srelab/watcher/pkg/handlers/sink"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// the bytes of stdout and stderr kept in each result
+const maxOutput = 64 * 1024
+
+func init() {
+	shared.Register("exec", func() shared.Handler { return new(Handler) })
+}
+
+// Handler runs the commands of the rules matching the events, e.g. flushing a cache when a ConfigMap changes.
+// A failed command fails the event, so the commands of the matched rules are run again by the retries
+type Handler struct {
+	config  *g.ExecConfig
+	rules   []*rule
+	slots   chan struct{}
+	results *Results
+	logger  log.Logger
+}
+
+type rule struct {
+	config  g.ExecRule
+	filter  *shared.Filter
+	encoder sink.Encoder
+	timeout time.Duration
+}
+
+func (h *Handler) Name() string                                       { return "exec" }
+func (h *Handler) RoutePrefix() string                                { return "/" + h.Name() }
+func (h *Handler) Close()                                             {}
+func (h *Handler) Created(ctx context.Context, e *shared.Event) error { return h.run(ctx, e) }
+func (h *Handler) Deleted(ctx context.Context, e *shared.Event) error { return h.run(ctx, e) }
+func (h *Handler) Updated(ctx context.Context, e *shared.Event) error { return h.run(ctx, e) }
+
+// Initialize the filter and the encoder of every rule
+func (h *Handler) Init(config *g.Configuration, itfs ...interface{}) error {
+	h.config = config.Handlers.ExecConfig
+	h.logger = log.With("handlers", h.Name())
+
+	concurrency := h.config.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	h.slots = make(chan struct{}, concurrency)
+	h.results = NewResults(h.config.History)
+
+	names := make(map[string]bool)
+	for _, config := range h.config.Rules {
+		if config.Name == "" || names[config.Name] {
+			return fmt.Errorf("rule name[%s] is empty or duplicated", config.Name)
+		}
+		names[config.Name] = true
+
+		if len(config.Command) == 0 {
+			return fmt.Errorf("command of rule[%s] is required", config.Name)
+		}
+
+		filter, err := shared.NewFilter(config.Filter)
+		if err != nil {
+			return fmt.Errorf("filter of rule[%s]: %s", config.Name, err)
+		}
+
+		encoder, err := sink.NewEncoder(config.Format)
+		if err != nil {
+			return fmt.Errorf("format of rule[%s]: %s", config.Name, err)
+		}
+
+		timeout := config.Timeout
+		if timeout <= 0 {
+			timeout = h.config.Timeout
+		}
+
+		h.rules = append(h.rules, &rule{config: config, filter: filter, encoder: encoder, timeout: timeout * time.Second})
+	}
+
+	return nil
+}
+
+// Runs the command of every matched rule, the failed rules are returned as an aggregate error
+func (h *Handler) run(ctx context.Context, e *shared.Event) error {
+	var errs []error
+	for _, r := range h.rules {
+		if !r.filter.Match(e) {
+			continue
+		}
+
+		result := h.execute(ctx, r, e)
+		h.results.Add(result)
+
+		if result.Error != "" {
+			h.logger.Errorf("[exec][%s] - %s %s error: %s", r.config.Name, e.Action, e.Key, result.Error)
+			errs = append(errs, fmt.Errorf("rule[%s]: %s", r.config.Name, result.Error))
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// Runs the command of the rule with the encoded event on stdin, waiting for a free slot first
+func (h *Handler) execute(ctx context.Context, r *rule, e *shared.Event) *Result {
+	result := &Result{
+		Rule:         r.config.Name,
+		Cluster:      e.Cluster,
+		ResourceType: e.ResourceType,
+		Action:       e.Action,
+		Key:          e.Key,
+		Command:      r.config.Command,
+		ExitCode:     -1,
+		StartedAt:    &shared.Datetime{Time: time.Now()},
+	}
+
+	body, err := r.encoder.Encode(e)
+	if err != nil {
+		result.Error = fmt.Sprintf("encode event: %s", err)
+		return result
+	}
+
+	select {
+	case h.slots <- struct{}{}:
+		defer func() { <-h.slots }()
+	case <-ctx.Done():
+		result.Error = fmt.Sprintf("no free slot: %s", ctx.Err())
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	stdout, stderr := &limitedBuffer{limit: maxOutput}, &limitedBuffer{limit: maxOutput}
+
+	cmd := osexec.CommandContext(ctx, r.config.Command[0], r.config.Command[1:]...)
+	cmd.Dir = r.config.Dir
+	cmd.Env = append(os.Environ(), environ(r, e)...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	start := time.Now()
+	err = cmd.Run()
+
+	result.Duration = time.Since(start).Seconds()
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+
+	if cmd.ProcessState != nil {
+		if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok {
+			result.ExitCode = status.ExitStatus()
+		}
+	}
+
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		result.Error = fmt.Sprintf("killed after %s", r.timeout)
+	case err != nil:
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+// The key fields of the event are passed as WATCHER_* variables, the variables of the rule come last
+func environ(r *rule, e *shared.Event) []string {
+	objectMeta := e.GetObjectMetaData()
+
+	env := []string{
+		"WATCHER_RULE=" + r.config.Name,
+		"WATCHER_CLUSTER=" + e.Cluster,
+		"WATCHER_RESOURCE_TYPE=" + string(e.ResourceType),
+		"WATCHER_ACTION=" + e.Action,
+		"WATCHER_NAMESPACE=" + e.Namespace,
+		"WATCHER_NAME=" + objectMeta.Name,
+		"WATCHER_KEY=" + e.Key,
+	}
+
+	for name, value := range r.config.Env {
+		env = append(env, name+"="+value)
+	}
+
+	return env
+}
+
+// limitedBuffer keeps the first limit bytes written, the rest is discarded
+type limitedBuffer struct {
+	bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.Buffer.Write(p[:room])
+		}
+
+		return len(p), nil
+	}
+
+	return b.Buffer.Write(p)
+}
+
+func (b *limitedBuffer) String() string {
+	if b.truncated {
+		return b.Buffer.String() + "\n[truncated]"
+	}
+
+	return b.Buffer.String()
+}
@@ -0,0 +1,80 @@
+package exec
+
+import (
+	"sync"
+
+	"github.com/srelab/watcher/pkg/handlers/shared"
+)
+
+// Result of a command run for an event, ExitCode is -1 when the command has not exited, e.g. not found.
+// Stdout and Stderr are truncated to maxOutput bytes, Duration is in seconds
+type Result struct {
+	ID           uint64              `json:"id"`
+	Rule         string              `json:"rule"`
+	Cluster      string              `json:"cluster"`
+	ResourceType shared.ResourceType `json:"resource_type"`
+	Action       string              `json:"action"`
+	Key          string              `json:"key"`
+	Command      []string            `json:"command"`
+	ExitCode     int                 `json:"exit_code"`
+	Stdout       string              `json:"stdout"`
+	Stderr       string              `json:"stderr"`
+	Error        string              `json:"error,omitempty"`
+	StartedAt    *shared.Datetime    `json:"started_at"`
+	Duration     float64             `json:"duration"`
+}
+
+// Results is a ring buffer of the latest results
+type Results struct {
+	lock sync.RWMutex
+
+	results []*Result
+	next    int
+	count   int
+	lastID  uint64
+}
+
+func NewResults(size int) *Results {
+	if size <= 0 {
+		size = 1
+	}
+
+	return &Results{results: make([]*Result, size)}
+}
+
+// Add adds the result, replacing the oldest one when the buffer is full
+func (r *Results) Add(result *Result) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.lastID++
+	result.ID = r.lastID
+
+	r.results[r.next] = result
+	r.next = (r.next + 1) % len(r.results)
+	if r.count < len(r.results) {
+		r.count++
+	}
+}
+
+// List returns the latest results first, filtered by the rule (empty for any rule)
+// and limited to limit results (0 for no limit). failed only returns the failed results
+func (r *Results) List(rule string, failed bool, limit int) []*Result {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	results := make([]*Result, 0)
+	for i := 1; i <= r.count; i++ {
+		result := r.results[(r.next-i+len(r.results))%len(r.results)]
+		if (rule != "" && result.Rule != rule) || (failed && result.Error == "") {
+			continue
+		}
+
+		results = append(results, result)
+		if limit > 0 && len(results) >= limit {
+			break
+		}
+	}
+
+	return results
+}
@@ -0,0 +1,45 @@
+package exec
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo"
+	"github.com/srelab/watcher/pkg/handlers/shared"
+)
+
+func (h *Handler) AddRoutes(group *echo.Group) {
+	group.GET(shared.EmptyPath, h.getName)
+	group.GET("/rules", h.getRules)
+	group.GET("/results", h.getResults)
+}
+
+func (h *Handler) getName(ctx echo.Context) error {
+	return shared.Responder{Status: http.StatusOK, Success: true, Result: h.Name()}.JSON(ctx)
+}
+
+// Get the configured rules, the environment variables are left out since they may hold secrets
+func (h *Handler) getRules(ctx echo.Context) error {
+	rules := make([]map[string]interface{}, 0, len(h.rules))
+	for _, r := range h.rules {
+		rules = append(rules, map[string]interface{}{
+			"name":    r.config.Name,
+			"command": r.config.Command,
+			"dir":     r.config.Dir,
+			"filter":  r.config.Filter,
+			"format":  r.config.Format,
+			"timeout": r.timeout.Seconds(),
+		})
+	}
+
+	return shared.Responder{Status: http.StatusOK, Success: true, Result: rules}.JSON(ctx)
+}
+
+// Get the latest results, filtered by rule, failed=true only returns the failed commands
+func (h *Handler) getResults(ctx echo.Context) error {
+	limit, _ := strconv.Atoi(ctx.QueryParam("limit"))
+	failed := ctx.QueryParam("failed") == "true"
+
+	results := h.results.List(ctx.QueryParam("rule"), failed, limit)
+	return shared.Responder{Status: http.StatusOK, Success: true, Result: results}.JSON(ctx)
+}
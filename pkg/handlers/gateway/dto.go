@@ -10,6 +10,17 @@ type MapResult struct {
 	Data   interface{} `json:"data"`
 }
 
+// Upstream of the gateway, with the servers registered to it
+type Upstream struct {
+	Name    string                     `json:"name"`
+	Servers []UnRegisterServicePayload `json:"servers"`
+}
+
+type UpstreamResult struct {
+	Status bool     `json:"status"`
+	Data   Upstream `json:"data"`
+}
+
 type RegisterServicePayload struct {
 	Host   string `json:"host" validate:"required"`
 	Type   string `json:"type" validate:"required"`
@@ -1,10 +1,13 @@
 package gateway
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"git.srelab.cn/go/resty"
@@ -14,86 +17,426 @@ import (
 	"github.com/srelab/watcher/pkg/handlers/shared"
 
 	apiV1 "k8s.io/api/core/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 )
 
+func init() {
+	shared.Register("gateway", func() shared.Handler { return new(Handler) })
+}
+
 type Handler struct {
-	logger  log.Logger
+	logger log.Logger
+
+	// replaced on reloads
+	lock    sync.RWMutex
 	configs []g.GatewayConfig
+	filter  *shared.Filter
+}
+
+func (h *Handler) Name() string                                       { return "gateway" }
+func (h *Handler) Handler() *Handler                                  { return h }
+func (h *Handler) RoutePrefix() string                                { return "/" + h.Name() }
+func (h *Handler) Close()                                             {}
+func (h *Handler) Created(ctx context.Context, e *shared.Event) error { return h.transition(ctx, e) }
+func (h *Handler) Updated(ctx context.Context, e *shared.Event) error { return h.transition(ctx, e) }
+
+// Register the services to the gateway when the pod becomes ready, and unregister them
+// when the pod becomes NotReady, starts terminating or changes the IP
+func (h *Handler) transition(ctx context.Context, e *shared.Event) error {
+	register, deregister, err := e.PodTransition()
+	if err != nil {
+		h.logger.Errorf("an error occurred while getting services: %s", err)
+		return nil
+	}
+
+	var errs []error
+	for _, service := range deregister {
+		if !h.Serves(service) {
+			continue
+		}
+
+		if err := h.DeleteService(ctx, service); err != nil {
+			errs = append(errs, fmt.Errorf("an error occurred while deleting the service: %s", err))
+		}
+	}
+
+	for _, service := range register {
+		if !h.Serves(service) {
+			continue
+		}
+
+		if err := h.CreateService(ctx, service); err != nil {
+			errs = append(errs, fmt.Errorf("an error occurred while creating the service: %s", err))
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
 }
 
-func (h *Handler) Name() string            { return "gateway" }
-func (h *Handler) Handler() *Handler       { return h }
-func (h *Handler) RoutePrefix() string     { return "/" + h.Name() }
-func (h *Handler) Close()                  {}
-func (h *Handler) Created(e *shared.Event) {}
-func (h *Handler) Updated(e *shared.Event) {}
+// Returns true when a gateway is configured for the namespace and cluster of the service
+func (h *Handler) Serves(service *shared.ServicePayload) bool {
+	return h.URL(service.Cluster, service.Namespace, "") != ""
+}
 
 // Remove the service from the gateway when it detects that the pod is destroyed
-func (h *Handler) Deleted(e *shared.Event) {
+// the services failed to be deleted are returned as an aggregate error, so the event is retried
+func (h *Handler) Deleted(ctx context.Context, e *shared.Event) error {
 	switch object := e.Object.(type) {
 	case *apiV1.Pod:
 		services, err := e.GetPodServices(object)
 		if err != nil {
 			h.logger.Errorf("an error occurred while getting services: %s", err)
-			return
+			return nil
 		}
 
+		var errs []error
 		for _, service := range services {
-			if err := h.DeleteService(service); err != nil {
-				h.logger.Errorf("an error occurred while deleting the service: %s", err)
+			if !h.Serves(service) {
+				continue
+			}
+
+			if err := h.DeleteService(ctx, service); err != nil {
+				errs = append(errs, fmt.Errorf("an error occurred while deleting the service: %s", err))
 			}
 		}
+
+		return utilerrors.NewAggregate(errs)
 	default:
-		return
+		return nil
 	}
 }
 
 // initialize the gateway handler
 // it will be responsible for handling kube events, regsiter and unregsiter pods
 func (h *Handler) Init(config *g.Configuration, itfs ...interface{}) error {
-	h.configs = config.Handlers.GatewayConfigs
 	h.logger = log.With("handlers", h.Name())
+	return h.Reload(config)
+}
+
+// Reload implements shared.Reloader, the gateways and the filter are replaced
+func (h *Handler) Reload(config *g.Configuration) error {
+	if len(config.Handlers.GatewayConfigs) == 0 {
+		return errors.New("gateway config is missing")
+	}
+
+	filter, err := shared.NewFilter(config.Handlers.GetFilterConfig(h.Name()))
+	if err != nil {
+		return err
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.configs = config.Handlers.GatewayConfigs
+	h.filter = filter
 
 	return nil
 }
 
+// Returns the configured gateways and the filter of the handler
+func (h *Handler) settings() ([]g.GatewayConfig, *shared.Filter) {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	return h.configs, h.filter
+}
+
 // Return request client , default 5 seconds timeout and automatically retry
 func (h *Handler) Request() *resty.Request {
 	r := resty.New().SetRetryCount(3).SetRetryWaitTime(5 * time.Second).SetRetryMaxWaitTime(10 * time.Second)
-	return r.R().SetHeader("Content-Type", "application/json")
+	return shared.InstrumentClient(h.Name(), r).R().SetHeader("Content-Type", "application/json")
 }
 
 // Returns the interface address of the gateway
+// cluster: kubernetes cluster, empty matches the gateway of any cluster
 // namespace: kubernetes namespace
 // path: request path
-func (h *Handler) URL(namespace, path string) string {
-	for _, config := range h.configs {
-		if config.Namespace == namespace {
-			return fmt.Sprintf("http://%s:%s/%s", config.Host, config.Port, strings.TrimLeft(path, "/"))
+func (h *Handler) URL(cluster, namespace, path string) string {
+	config, ok := h.gatewayConfig(cluster, namespace)
+	if !ok {
+		return ""
+	}
+
+	return configURL(config, path)
+}
+
+// Returns the first gateway config of the namespace and cluster, false when there is none
+func (h *Handler) gatewayConfig(cluster, namespace string) (g.GatewayConfig, bool) {
+	configs, _ := h.settings()
+	for _, config := range configs {
+		if config.Namespace != namespace {
+			continue
+		}
+
+		if cluster != "" && config.Cluster != "" && config.Cluster != cluster {
+			continue
+		}
+
+		return config, true
+	}
+
+	return g.GatewayConfig{}, false
+}
+
+func configURL(config g.GatewayConfig, path string) string {
+	return fmt.Sprintf("http://%s:%s/%s", config.Host, config.Port, strings.TrimLeft(path, "/"))
+}
+
+// Check implements shared.HealthChecker, every configured gateway must answer the upstream list
+func (h *Handler) Check(ctx context.Context) error {
+	configs, _ := h.settings()
+
+	var errs []error
+	for _, config := range configs {
+		response, err := shared.InstrumentClient(h.Name(), resty.New().SetTimeout(5*time.Second)).R().SetContext(ctx).Get(configURL(config, "/upstreams"))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("gateway of namespace[%s]: %s", config.Namespace, err))
+			continue
+		}
+
+		if response.StatusCode() != http.StatusOK {
+			errs = append(errs, fmt.Errorf("gateway of namespace[%s], status code[%d]", config.Namespace, response.StatusCode()))
 		}
 	}
 
-	return ""
+	return utilerrors.NewAggregate(errs)
+}
+
+// Returns the upstreams of the gateway, keyed by the name
+func (h *Handler) Upstreams(ctx context.Context, config g.GatewayConfig) (map[string]Upstream, error) {
+	result := &SliceResult{}
+	response, err := h.Request().SetContext(ctx).SetResult(result).Get(configURL(config, "/upstreams"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get upstream list: %s", err)
+	}
+
+	if response.StatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("failed to get upstream list, status code[%d]: %s", response.StatusCode(), response.Body())
+	}
+
+	upstreams := make(map[string]Upstream)
+	for _, item := range result.Data {
+		// the list contains either the names or the upstream objects
+		var name string
+		switch object := item.(type) {
+		case string:
+			name = object
+		case map[string]interface{}:
+			name, _ = object["name"].(string)
+		}
+
+		if name == "" {
+			continue
+		}
+
+		upstream := &UpstreamResult{}
+		response, err := h.Request().SetContext(ctx).SetResult(upstream).Get(configURL(config, "/upstreams/"+name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get upstream[%s]: %s", name, err)
+		}
+
+		if response.StatusCode() != http.StatusOK {
+			return nil, fmt.Errorf("failed to get upstream[%s], status code[%d]: %s", name, response.StatusCode(), response.Body())
+		}
+
+		upstream.Data.Name = name
+		upstreams[name] = upstream.Data
+	}
+
+	return upstreams, nil
+}
+
+// the services of the cached pods, by the selection of the handler
+type podServices struct {
+	// the services of the ready pods selected by the filter
+	wanted []*shared.ServicePayload
+
+	// the services of the pods not selected by the filter, their servers are left alone
+	excluded []*shared.ServicePayload
+
+	// the services of the selected pods which are not ready
+	unready []*shared.ServicePayload
+}
+
+// Reconcile registers the services of the cached ready pods missing in the upstreams of each gateway,
+// and unregisters the servers whose pods do not exist anymore. Only the upstreams declared by the pods
+// are reconciled, the servers of the other upstreams are left alone, e.g. registered through the core API
+func (h *Handler) Reconcile(ctx context.Context, lister shared.Lister) error {
+	events, err := lister.List(shared.ResourceTypePod)
+	if err != nil {
+		return err
+	}
+
+	configs, filter := h.settings()
+	services := new(podServices)
+	for _, e := range events {
+		pod, ok := e.Object.(*apiV1.Pod)
+		if !ok {
+			continue
+		}
+
+		podServices, err := e.GetPodServices(pod)
+		if err != nil {
+			continue
+		}
+
+		switch {
+		case !filter.Match(e):
+			services.excluded = append(services.excluded, podServices...)
+		case !shared.PodReady(pod):
+			services.unready = append(services.unready, podServices...)
+		default:
+			services.wanted = append(services.wanted, podServices...)
+		}
+	}
+
+	var errs []error
+	for _, config := range configs {
+		if err := h.reconcileConfig(ctx, config, filter, services); err != nil {
+			errs = append(errs, fmt.Errorf("gateway of namespace[%s]: %s", config.Namespace, err))
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+func (h *Handler) reconcileConfig(ctx context.Context, config g.GatewayConfig, filter *shared.Filter, services *podServices) error {
+	serves := func(service *shared.ServicePayload) bool {
+		return service.Namespace == config.Namespace && (config.Cluster == "" || config.Cluster == service.Cluster)
+	}
+
+	// upstream name => host:port => service
+	wanted := make(map[string]map[string]*shared.ServicePayload)
+	for _, service := range services.wanted {
+		if !serves(service) {
+			continue
+		}
+
+		if wanted[service.Name] == nil {
+			wanted[service.Name] = make(map[string]*shared.ServicePayload)
+		}
+		wanted[service.Name][service.String()] = service
+	}
+
+	// the upstreams declared by the pods, and the servers of the pods not selected
+	managed, excluded := make(map[string]bool), make(map[string]bool)
+	for _, list := range [][]*shared.ServicePayload{services.wanted, services.unready, services.excluded} {
+		for _, service := range list {
+			if serves(service) {
+				managed[service.Name] = true
+			}
+		}
+	}
+
+	for _, service := range services.excluded {
+		if serves(service) {
+			excluded[service.Name+"/"+service.String()] = true
+		}
+	}
+
+	upstreams, err := h.Upstreams(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	var (
+		errs                        []error
+		created, deleted, unmanaged int
+	)
+
+	for name, servers := range wanted {
+		registered := make(map[string]bool)
+		for _, server := range upstreams[name].Servers {
+			registered[fmt.Sprintf("%s:%d", server.Host, server.Port)] = true
+		}
+
+		for address, service := range servers {
+			if registered[address] {
+				continue
+			}
+
+			if err := h.register(ctx, config, service); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			created++
+		}
+	}
+
+	// the pods of a namespace not watched are not cached, its servers cannot be orphaned
+	owned := g.Config().Kubernetes.Watches(config.Namespace) && filter.MatchNamespace(config.Namespace)
+
+	orphaned := make([]*shared.ServicePayload, 0)
+	for name, upstream := range upstreams {
+		for _, server := range upstream.Servers {
+			address := fmt.Sprintf("%s:%d", server.Host, server.Port)
+			if _, ok := wanted[name][address]; ok {
+				continue
+			}
+
+			if !owned || !managed[name] || excluded[name+"/"+address] {
+				unmanaged++
+				continue
+			}
+
+			orphaned = append(orphaned, &shared.ServicePayload{
+				Name:      name,
+				Namespace: config.Namespace,
+				Cluster:   config.Cluster,
+				Host:      server.Host,
+				Port:      server.Port,
+			})
+		}
+	}
+
+	for _, service := range orphaned {
+		if config.MaxDeletions > 0 && deleted >= config.MaxDeletions {
+			errs = append(errs, fmt.Errorf(
+				"%d orphaned servers left, the limit of %d deletions is reached", len(orphaned)-deleted, config.MaxDeletions,
+			))
+			break
+		}
+
+		if err := h.unregister(ctx, config, service); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		deleted++
+	}
+
+	h.logger.Infof(
+		"[gateway][%s] - reconciled, %d services created, %d orphaned servers deleted, %d unmanaged servers left alone",
+		config.Namespace, created, deleted, unmanaged,
+	)
+	return utilerrors.NewAggregate(errs)
 }
 
 // Write service information to the API Gateway
-func (h *Handler) CreateService(service *shared.ServicePayload) error {
-	// Get the URL of the handler in memory, when the `namespace` does not exist, skip the service
-	url := h.URL(service.Namespace, fmt.Sprintf("/upstreams/%s/register", service.Name))
-	if url == "" {
+func (h *Handler) CreateService(ctx context.Context, service *shared.ServicePayload) error {
+	// Get the config of the handler in memory, when the `namespace` does not exist, skip the service
+	config, ok := h.gatewayConfig(service.Cluster, service.Namespace)
+	if !ok {
 		return fmt.Errorf(
 			"namespace `%s` has no associated gateway config, %s register skipped",
 			service.Namespace, service.String(),
 		)
 	}
 
+	return h.register(ctx, config, service)
+}
+
+// Registers the service to the gateway of the config, the reconciliation registers
+// to the gateway it reconciles, whichever config matches the service first
+func (h *Handler) register(ctx context.Context, config g.GatewayConfig, service *shared.ServicePayload) error {
+	url := configURL(config, fmt.Sprintf("/upstreams/%s/register", service.Name))
+
 	var (
 		response *resty.Response
 		err      error
 	)
 
 	if service.Protocol == "http" {
-		response, err = h.Request().SetBody(map[string]string{
+		response, err = h.Request().SetContext(ctx).SetBody(map[string]string{
 			"host":    service.Host,
 			"type":    service.Protocol,
 			"port":    strconv.Itoa(service.Port),
@@ -101,7 +444,7 @@ func (h *Handler) CreateService(service *shared.ServicePayload) error {
 			"hc_port": strconv.Itoa(service.HealthCheck.Port),
 		}).Post(url)
 	} else {
-		response, err = h.Request().SetBody(map[string]string{
+		response, err = h.Request().SetContext(ctx).SetBody(map[string]string{
 			"host": service.Host,
 			"type": "general",
 			"port": strconv.Itoa(service.Port),
@@ -121,17 +464,24 @@ func (h *Handler) CreateService(service *shared.ServicePayload) error {
 }
 
 // Remove service information from the API Gateway
-func (h *Handler) DeleteService(service *shared.ServicePayload) error {
-	// Get the URL of the handler in memory, when the `namespace` does not exist, skip the service
-	url := h.URL(service.Namespace, fmt.Sprintf("/upstreams/%s/unregister", service.Name))
-	if url == "" {
+func (h *Handler) DeleteService(ctx context.Context, service *shared.ServicePayload) error {
+	// Get the config of the handler in memory, when the `namespace` does not exist, skip the service
+	config, ok := h.gatewayConfig(service.Cluster, service.Namespace)
+	if !ok {
 		return fmt.Errorf(
 			"namespace `%s` has no associated gateway config, %s register skipped",
 			service.Namespace, service.String(),
 		)
 	}
 
-	response, err := h.Request().SetBody(service).Post(url)
+	return h.unregister(ctx, config, service)
+}
+
+// Unregisters the service from the gateway of the config
+func (h *Handler) unregister(ctx context.Context, config g.GatewayConfig, service *shared.ServicePayload) error {
+	url := configURL(config, fmt.Sprintf("/upstreams/%s/unregister", service.Name))
+
+	response, err := h.Request().SetContext(ctx).SetBody(service).Post(url)
 	if err != nil {
 		return fmt.Errorf("pod[%s] - [%s] unregister error: %s", service.Name, response.String(), err)
 	}
@@ -25,8 +25,10 @@ func (h *Handler) getName(ctx echo.Context) error {
 }
 
 func (h *Handler) getNamespaces(ctx echo.Context) error {
+	configs, _ := h.settings()
+
 	namespaces := make([]string, 0)
-	for _, config := range h.configs {
+	for _, config := range configs {
 		namespaces = append(namespaces, config.Namespace)
 	}
 
@@ -35,7 +37,7 @@ func (h *Handler) getNamespaces(ctx echo.Context) error {
 
 func (h *Handler) getUpstreams(ctx echo.Context) error {
 	namespace := ctx.Param("namespace")
-	url := h.URL(namespace, "/upstreams")
+	url := h.URL(ctx.QueryParam("cluster"), namespace, "/upstreams")
 	if url == "" {
 		return fmt.Errorf("namespace `%s` has no associated gateway config", namespace)
 	}
@@ -58,7 +60,7 @@ func (h *Handler) getUpstreamsByName(ctx echo.Context) error {
 	namespace := ctx.Param("namespace")
 	upstream := ctx.Param("upstream")
 
-	url := h.URL(namespace, fmt.Sprintf("/upstreams/%s", upstream))
+	url := h.URL(ctx.QueryParam("cluster"), namespace, fmt.Sprintf("/upstreams/%s", upstream))
 	if url == "" {
 		return fmt.Errorf("namespace `%s` has no associated gateway config", namespace)
 	}
@@ -88,7 +90,7 @@ func (h *Handler) registerServerToUpstream(ctx echo.Context) error {
 	upstream := ctx.Param("upstream")
 
 	// Get the URL of the handler in memory, when the `namespace` does not exist, skip the service
-	url := h.URL(namespace, fmt.Sprintf("/upstreams/%s/register", upstream))
+	url := h.URL(ctx.QueryParam("cluster"), namespace, fmt.Sprintf("/upstreams/%s/register", upstream))
 	if url == "" {
 		err = fmt.Errorf("namespace `%s` has no associated gateway config, %s register skipped", namespace, upstream)
 		return shared.Responder{Status: http.StatusBadRequest, Success: false, Msg: err}.JSON(ctx)
@@ -137,7 +139,7 @@ func (h *Handler) unregisterServerFromUpstream(ctx echo.Context) error {
 	upstream := ctx.Param("upstream")
 
 	// Get the URL of the handler in memory, when the `namespace` does not exist, skip the service
-	url := h.URL(namespace, fmt.Sprintf("/upstreams/%s/unregister", upstream))
+	url := h.URL(ctx.QueryParam("cluster"), namespace, fmt.Sprintf("/upstreams/%s/unregister", upstream))
 	if url == "" {
 		err = fmt.Errorf("namespace `%s` has no associated gateway config, %s register skipped", namespace, upstream)
 		return shared.Responder{Status: http.StatusBadRequest, Success: false, Msg: err}.JSON(ctx)
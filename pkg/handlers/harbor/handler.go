@@ -1,11 +1,13 @@
 package harbor
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"git.srelab.cn/go/resty"
@@ -14,22 +16,33 @@ import (
 	"github.com/srelab/watcher/pkg/handlers/shared"
 )
 
+func init() {
+	shared.Register("harbor", func() shared.Handler { return new(Handler) })
+}
+
 var sid string
 
 type Handler struct {
-	config *g.HarborConfig
 	logger log.Logger
+
+	// replaced on reloads
+	lock   sync.RWMutex
+	config *g.HarborConfig
 }
 
-func (h *Handler) Name() string            { return "harbor" }
-func (h *Handler) Handler() *Handler       { return h }
-func (h *Handler) RoutePrefix() string     { return "/" + h.Name() }
-func (h *Handler) Close()                  {}
-func (h *Handler) Created(e *shared.Event) {}
-func (h *Handler) Deleted(e *shared.Event) {}
-func (h *Handler) Updated(e *shared.Event) {}
+func (h *Handler) Name() string                                       { return "harbor" }
+func (h *Handler) Handler() *Handler                                  { return h }
+func (h *Handler) RoutePrefix() string                                { return "/" + h.Name() }
+func (h *Handler) Close()                                             {}
+func (h *Handler) Created(ctx context.Context, e *shared.Event) error { return nil }
+func (h *Handler) Deleted(ctx context.Context, e *shared.Event) error { return nil }
+func (h *Handler) Updated(ctx context.Context, e *shared.Event) error { return nil }
 
 func (h *Handler) Init(config *g.Configuration, itfs ...interface{}) error {
+	if config.Handlers.HarborConfig == nil {
+		return errors.New("harbor config is missing")
+	}
+
 	h.config = config.Handlers.HarborConfig
 	h.logger = log.With("handlers", h.Name())
 
@@ -41,20 +54,52 @@ func (h *Handler) Init(config *g.Configuration, itfs ...interface{}) error {
 	return nil
 }
 
+// Reload implements shared.Reloader, the session is checked again with the new endpoint
+// and credentials by the next request
+func (h *Handler) Reload(config *g.Configuration) error {
+	if config.Handlers.HarborConfig == nil {
+		return errors.New("harbor config is missing")
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.config = config.Handlers.HarborConfig
+	return nil
+}
+
+func (h *Handler) getConfig() *g.HarborConfig {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	return h.config
+}
+
+// the checks of the readiness are not retried, each request is given at most checkTimeout
+const checkTimeout = 3 * time.Second
+
 // Return request client
 // Ensure that sid is valid by requesting the /api/users/current interface
 // and attempting to log in when the request fails
 func (h *Handler) Request() *resty.Request {
-	r := resty.New().SetRetryCount(3).SetRetryWaitTime(5 * time.Second).SetRetryMaxWaitTime(10 * time.Second)
+	client := resty.New().SetRetryCount(3).SetRetryWaitTime(5 * time.Second).SetRetryMaxWaitTime(10 * time.Second)
+	return h.session(context.Background(), client)
+}
+
+// Returns a request of the client with a valid sid, the session and login requests are sent with ctx
+func (h *Handler) session(ctx context.Context, r *resty.Client) *resty.Request {
+	config := h.getConfig()
+
 	r.SetTLSClientConfig(&tls.Config{InsecureSkipVerify: true}).SetCookie(&http.Cookie{
 		Name: "sid", Value: sid, HttpOnly: true,
-	}).SetHostURL(h.config.Endpoint)
+	}).SetHostURL(config.Endpoint)
+	shared.InstrumentClient(h.Name(), r)
 
-	response, err := r.R().Get("/api/users/current")
+	response, err := r.R().SetContext(ctx).Get("/api/users/current")
 	if response.StatusCode() != http.StatusOK || err != nil {
 		// form data
-		fd := map[string]string{"principal": h.config.Username, "password": h.config.Password}
-		response, err = r.R().SetFormData(fd).Post("/c/login")
+		fd := map[string]string{"principal": config.Username, "password": config.Password}
+		response, err = r.R().SetContext(ctx).SetFormData(fd).Post("/c/login")
 
 		// Check if the login is successful, and return an error if it fails.
 		if response.StatusCode() == http.StatusOK {
@@ -70,7 +115,24 @@ func (h *Handler) Request() *resty.Request {
 		}
 	}
 
-	return r.R().SetHeader("Content-Type", "application/json").SetHeader("Cookie", "sid="+sid)
+	return r.R().SetContext(ctx).SetHeader("Content-Type", "application/json").SetHeader("Cookie", "sid="+sid)
+}
+
+// Check implements shared.HealthChecker, the current user must be returned with the session.
+// The client of the check does not retry, so an outage of the harbor does not stall the readiness
+func (h *Handler) Check(ctx context.Context) error {
+	client := resty.New().SetTimeout(checkTimeout)
+
+	response, err := h.session(ctx, client).Get(h.URL("/api/users/current"))
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode() != http.StatusOK {
+		return fmt.Errorf("get current user, status code[%d]", response.StatusCode())
+	}
+
+	return nil
 }
 
 // Returns the interface address of the harbor
@@ -78,7 +140,7 @@ func (h *Handler) Request() *resty.Request {
 func (h *Handler) URL(path string) string {
 	return fmt.Sprintf(
 		"%s/%s",
-		strings.TrimRight(h.config.Endpoint, "/"),
+		strings.TrimRight(h.getConfig().Endpoint, "/"),
 		strings.TrimLeft(path, "/"),
 	)
 }
@@ -17,7 +17,7 @@ func (h *Handler) getEvent(ctx echo.Context) error {
 		return shared.Responder{Status: http.StatusBadRequest, Success: false, Msg: err}.JSON(ctx)
 	}
 
-	events, err := h.handlers.kube.CoreV1().Events(ctx.Param("ns")).List(metaV1.ListOptions{
+	events, err := h.kube(ctx).CoreV1().Events(namespace(ctx)).List(metaV1.ListOptions{
 		FieldSelector: p.FieldSelector,
 		LabelSelector: p.LabelSelector,
 		Continue:      p.Continue,
@@ -28,6 +28,10 @@ func (h *Handler) getEvent(ctx echo.Context) error {
 		return shared.Responder{Status: http.StatusInternalServerError, Success: false, Msg: err}.JSON(ctx)
 	}
 
+	if err := filterList(events, watchedNamespace(ctx)); err != nil {
+		return shared.Responder{Status: http.StatusInternalServerError, Success: false, Msg: err}.JSON(ctx)
+	}
+
 	return shared.Responder{Status: http.StatusOK, Success: true, Result: events}.JSON(ctx)
 }
 
@@ -39,7 +43,7 @@ func (h *Handler) createEvent(ctx echo.Context) error {
 		return shared.Responder{Status: http.StatusBadRequest, Success: false, Msg: err}.JSON(ctx)
 	}
 
-	event, err := h.handlers.kube.CoreV1().Events(ctx.Param("ns")).Create(event)
+	event, err := h.kube(ctx).CoreV1().Events(ctx.Param("ns")).Create(event)
 
 	if err != nil {
 		return shared.Responder{Status: http.StatusInternalServerError, Success: false, Msg: err}.JSON(ctx)
@@ -56,7 +60,7 @@ func (h *Handler) updateEvent(ctx echo.Context) error {
 		return shared.Responder{Status: http.StatusBadRequest, Success: false, Msg: err}.JSON(ctx)
 	}
 
-	event, err := h.handlers.kube.CoreV1().Pods(ctx.Param("ns")).Update(event)
+	event, err := h.kube(ctx).CoreV1().Pods(ctx.Param("ns")).Update(event)
 
 	if err != nil {
 		return shared.Responder{Status: http.StatusInternalServerError, Success: false, Msg: err}.JSON(ctx)
@@ -87,7 +91,7 @@ func (h *Handler) deleteEvent(ctx echo.Context) error {
 		deletePolicy = metaV1.DeletePropagationForeground
 	}
 
-	err := h.handlers.kube.CoreV1().Events(ctx.Param("ns")).Delete(p.Name, &metaV1.DeleteOptions{
+	err := h.kube(ctx).CoreV1().Events(ctx.Param("ns")).Delete(p.Name, &metaV1.DeleteOptions{
 		PropagationPolicy:  &deletePolicy,
 		GracePeriodSeconds: p.GracePeriodSeconds,
 	})
@@ -1,36 +1,117 @@
 package k8s
 
 import (
+	"context"
+	"errors"
+
+	"github.com/labstack/echo"
 	"github.com/srelab/watcher/pkg/g"
 	"github.com/srelab/watcher/pkg/handlers/shared"
+	"github.com/srelab/watcher/pkg/kube"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 )
 
+func init() {
+	shared.Register("k8s", func() shared.Handler { return new(Handler) })
+}
+
 // Default handler implements Handler interface,
 // print each event with JSON format
 type Handler struct {
 	handlers struct {
-		kube kubernetes.Interface
+		clusters kube.Clusters
 	}
 }
 
-func (h *Handler) Name() string            { return "k8s" }
-func (h *Handler) Handler() *Handler       { return h }
-func (h *Handler) RoutePrefix() string     { return "/" + h.Name() }
-func (h *Handler) Close()                  {}
-func (h *Handler) Created(e *shared.Event) {}
-func (h *Handler) Deleted(e *shared.Event) {}
-func (h *Handler) Updated(e *shared.Event) {}
+func (h *Handler) Name() string                                       { return "k8s" }
+func (h *Handler) Handler() *Handler                                  { return h }
+func (h *Handler) RoutePrefix() string                                { return "/" + h.Name() }
+func (h *Handler) Close()                                             {}
+func (h *Handler) Created(ctx context.Context, e *shared.Event) error { return nil }
+func (h *Handler) Deleted(ctx context.Context, e *shared.Event) error { return nil }
+func (h *Handler) Updated(ctx context.Context, e *shared.Event) error { return nil }
 
 // Init initializes handler configuration
 // Do nothing for default handler
 func (h *Handler) Init(config *g.Configuration, itfs ...interface{}) error {
 	for _, itf := range itfs {
 		switch object := itf.(type) {
-		case kubernetes.Interface:
-			h.handlers.kube = object
+		case kube.Clusters:
+			h.handlers.clusters = object
 		}
 	}
 
+	if len(h.handlers.clusters) == 0 {
+		return errors.New("no kubernetes cluster available")
+	}
+
 	return nil
 }
+
+// AllNamespaces in the path of the list routes lists the objects of all the watched namespaces
+const AllNamespaces = "_all"
+
+// Returns the namespace in the request path, AllNamespaces is listed cluster-wide
+func namespace(ctx echo.Context) string {
+	if ctx.Param("ns") == AllNamespaces {
+		return metaV1.NamespaceAll
+	}
+
+	return ctx.Param("ns")
+}
+
+// Returns the filter of the list of AllNamespaces, the objects of the namespaces not watched are dropped
+func watchedNamespace(ctx echo.Context) func(object metaV1.Object) bool {
+	if ctx.Param("ns") != AllNamespaces {
+		return nil
+	}
+
+	return func(object metaV1.Object) bool {
+		return g.Config().Kubernetes.Watches(object.GetNamespace())
+	}
+}
+
+// Keeps the watched namespaces in a list of namespaces
+func watchedName(object metaV1.Object) bool {
+	return g.Config().Kubernetes.Watches(object.GetName())
+}
+
+// Keeps the items of the list matched by the filter, nothing is dropped without filter.
+// The items are dropped after the page is fetched, so a page may hold less than the limit
+func filterList(list runtime.Object, filter func(object metaV1.Object) bool) error {
+	if filter == nil {
+		return nil
+	}
+
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return err
+	}
+
+	filtered := make([]runtime.Object, 0, len(items))
+	for _, item := range items {
+		accessor, err := meta.Accessor(item)
+		if err != nil {
+			return err
+		}
+
+		if filter(accessor) {
+			filtered = append(filtered, item)
+		}
+	}
+
+	return meta.SetList(list, filtered)
+}
+
+// Returns the client of the cluster in the request path,
+// the routes without cluster are served by the default cluster
+func (h *Handler) kube(ctx echo.Context) kubernetes.Interface {
+	if cluster := h.handlers.clusters.Get(ctx.Param("cluster")); cluster != nil {
+		return cluster.Client
+	}
+
+	return h.handlers.clusters[0].Client
+}
@@ -18,7 +18,7 @@ func (h *Handler) getNamespace(ctx echo.Context) error {
 		return shared.Responder{Status: http.StatusBadRequest, Success: false, Msg: err}.JSON(ctx)
 	}
 
-	namespaces, err := h.handlers.kube.CoreV1().Namespaces().List(metaV1.ListOptions{
+	namespaces, err := h.kube(ctx).CoreV1().Namespaces().List(metaV1.ListOptions{
 		FieldSelector: p.FieldSelector,
 		LabelSelector: p.LabelSelector,
 		Continue:      p.Continue,
@@ -29,6 +29,13 @@ func (h *Handler) getNamespace(ctx echo.Context) error {
 		return shared.Responder{Status: http.StatusInternalServerError, Success: false, Msg: err}.JSON(ctx)
 	}
 
+	// only the watched namespaces are listed by default, all=true lists every namespace
+	if ctx.QueryParam("all") != "true" {
+		if err := filterList(namespaces, watchedName); err != nil {
+			return shared.Responder{Status: http.StatusInternalServerError, Success: false, Msg: err}.JSON(ctx)
+		}
+	}
+
 	return shared.Responder{Status: http.StatusOK, Success: true, Result: namespaces}.JSON(ctx)
 }
 
@@ -40,7 +47,7 @@ func (h *Handler) createNamespace(ctx echo.Context) error {
 		return shared.Responder{Status: http.StatusBadRequest, Success: false, Msg: err}.JSON(ctx)
 	}
 
-	namespace, err := h.handlers.kube.CoreV1().Namespaces().Create(namespace)
+	namespace, err := h.kube(ctx).CoreV1().Namespaces().Create(namespace)
 	if err != nil {
 		return shared.Responder{Status: http.StatusInternalServerError, Success: false, Msg: err}.JSON(ctx)
 	}
@@ -59,7 +66,7 @@ func (h *Handler) updateNamespace(ctx echo.Context) error {
 		return shared.Responder{Status: http.StatusBadRequest, Success: false, Msg: err}.JSON(ctx)
 	}
 
-	namespace, err := h.handlers.kube.CoreV1().Namespaces().Update(namespace)
+	namespace, err := h.kube(ctx).CoreV1().Namespaces().Update(namespace)
 	if err != nil {
 		return shared.Responder{Status: http.StatusInternalServerError, Success: false, Msg: err}.JSON(ctx)
 	}
@@ -89,7 +96,7 @@ func (h *Handler) deleteNamespace(ctx echo.Context) error {
 		deletePolicy = metaV1.DeletePropagationForeground
 	}
 
-	err := h.handlers.kube.CoreV1().Namespaces().Delete(p.Name, &metaV1.DeleteOptions{
+	err := h.kube(ctx).CoreV1().Namespaces().Delete(p.Name, &metaV1.DeleteOptions{
 		PropagationPolicy:  &deletePolicy,
 		GracePeriodSeconds: p.GracePeriodSeconds,
 	})
@@ -18,7 +18,7 @@ func (h *Handler) getPod(ctx echo.Context) error {
 		return shared.Responder{Status: http.StatusBadRequest, Success: false, Msg: err}.JSON(ctx)
 	}
 
-	pods, err := h.handlers.kube.CoreV1().Pods(ctx.Param("ns")).List(metaV1.ListOptions{
+	pods, err := h.kube(ctx).CoreV1().Pods(namespace(ctx)).List(metaV1.ListOptions{
 		FieldSelector: p.FieldSelector,
 		LabelSelector: p.LabelSelector,
 		Continue:      p.Continue,
@@ -29,6 +29,10 @@ func (h *Handler) getPod(ctx echo.Context) error {
 		return shared.Responder{Status: http.StatusInternalServerError, Success: false, Msg: err}.JSON(ctx)
 	}
 
+	if err := filterList(pods, watchedNamespace(ctx)); err != nil {
+		return shared.Responder{Status: http.StatusInternalServerError, Success: false, Msg: err}.JSON(ctx)
+	}
+
 	return shared.Responder{Status: http.StatusOK, Success: true, Result: pods}.JSON(ctx)
 }
 
@@ -40,7 +44,7 @@ func (h *Handler) createPod(ctx echo.Context) error {
 		return shared.Responder{Status: http.StatusBadRequest, Success: false, Msg: err}.JSON(ctx)
 	}
 
-	pod, err := h.handlers.kube.CoreV1().Pods(ctx.Param("ns")).Create(pod)
+	pod, err := h.kube(ctx).CoreV1().Pods(ctx.Param("ns")).Create(pod)
 
 	if err != nil {
 		return shared.Responder{Status: http.StatusInternalServerError, Success: false, Msg: err}.JSON(ctx)
@@ -62,7 +66,7 @@ func (h *Handler) updatePod(ctx echo.Context) error {
 		return shared.Responder{Status: http.StatusBadRequest, Success: false, Msg: err}.JSON(ctx)
 	}
 
-	pod, err := h.handlers.kube.CoreV1().Pods(ctx.Param("ns")).Update(pod)
+	pod, err := h.kube(ctx).CoreV1().Pods(ctx.Param("ns")).Update(pod)
 
 	if err != nil {
 		return shared.Responder{Status: http.StatusInternalServerError, Success: false, Msg: err}.JSON(ctx)
@@ -93,7 +97,7 @@ func (h *Handler) deletePod(ctx echo.Context) error {
 		deletePolicy = metaV1.DeletePropagationForeground
 	}
 
-	err := h.handlers.kube.CoreV1().Pods(ctx.Param("ns")).Delete(p.Name, &metaV1.DeleteOptions{
+	err := h.kube(ctx).CoreV1().Pods(ctx.Param("ns")).Delete(p.Name, &metaV1.DeleteOptions{
 		PropagationPolicy:  &deletePolicy,
 		GracePeriodSeconds: p.GracePeriodSeconds,
 	})
@@ -109,7 +113,7 @@ func (h *Handler) deletePod(ctx echo.Context) error {
 // For now, this is just a test interface
 func (h *Handler) getPodLogs(ctx echo.Context) error {
 	name := ctx.Param("name")
-	req := h.handlers.kube.CoreV1().Pods(ctx.Param("ns")).GetLogs(name, &coreV1.PodLogOptions{})
+	req := h.kube(ctx).CoreV1().Pods(ctx.Param("ns")).GetLogs(name, &coreV1.PodLogOptions{})
 
 	rc, err := req.Stream()
 	if err != nil {
@@ -1,6 +1,7 @@
 package k8s
 
 import (
+	"fmt"
 	"net/http"
 
 	"github.com/labstack/echo"
@@ -9,7 +10,24 @@ import (
 
 func (h *Handler) AddRoutes(group *echo.Group) {
 	group.GET(shared.EmptyPath, h.getName)
+	group.GET("/clusters", h.getClusters)
 
+	// The routes without cluster are kept for the default cluster
+	h.addClusterRoutes(group)
+	h.addClusterRoutes(group.Group("/clusters/:cluster", func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			if h.handlers.clusters.Get(ctx.Param("cluster")) == nil {
+				err := fmt.Errorf("cluster `%s` does not exist", ctx.Param("cluster"))
+				return shared.Responder{Status: http.StatusNotFound, Success: false, Msg: err}.JSON(ctx)
+			}
+
+			return next(ctx)
+		}
+	}))
+}
+
+// Add the routes of the kubernetes resources, scoped by the cluster of the group
+func (h *Handler) addClusterRoutes(group *echo.Group) {
 	nodeGroup := group.Group("/nodes")
 	nodeGroup.GET(shared.EmptyPath, h.getNode)
 
@@ -57,3 +75,7 @@ func (h *Handler) AddRoutes(group *echo.Group) {
 func (h *Handler) getName(ctx echo.Context) error {
 	return shared.Responder{Status: http.StatusOK, Success: true, Result: h.Name()}.JSON(ctx)
 }
+
+func (h *Handler) getClusters(ctx echo.Context) error {
+	return shared.Responder{Status: http.StatusOK, Success: true, Result: h.handlers.clusters.Names()}.JSON(ctx)
+}
@@ -0,0 +1,46 @@
+package rules
+
+import (
+	"context"
+	"errors"
+
+	"github.com/srelab/common/log"
+	"github.com/srelab/watcher/pkg/controller"
+	"github.com/srelab/watcher/pkg/g"
+	"github.com/srelab/watcher/pkg/handlers/shared"
+)
+
+func init() {
+	shared.Register("rules", func() shared.Handler { return new(Handler) })
+}
+
+// Handler exposes the routing rules of the dispatcher, and tests the rules against sample objects
+type Handler struct {
+	dispatcher *controller.Dispatcher
+	logger     log.Logger
+}
+
+func (h *Handler) Name() string                                       { return "rules" }
+func (h *Handler) RoutePrefix() string                                { return "/" + h.Name() }
+func (h *Handler) Close()                                             {}
+func (h *Handler) Created(ctx context.Context, e *shared.Event) error { return nil }
+func (h *Handler) Deleted(ctx context.Context, e *shared.Event) error { return nil }
+func (h *Handler) Updated(ctx context.Context, e *shared.Event) error { return nil }
+
+// The rules are compiled and applied by the dispatcher
+func (h *Handler) Init(config *g.Configuration, itfs ...interface{}) error {
+	h.logger = log.With("handlers", h.Name())
+
+	for _, itf := range itfs {
+		switch object := itf.(type) {
+		case *controller.Dispatcher:
+			h.dispatcher = object
+		}
+	}
+
+	if h.dispatcher == nil {
+		return errors.New("dispatcher does not exist")
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package rules
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/srelab/watcher/pkg/g"
+	"github.com/srelab/watcher/pkg/handlers/shared"
+	"github.com/srelab/watcher/pkg/routing"
+)
+
+// The rule to test, either a configured rule by its name or an expression,
+// and the sample event, whose objects are given in their JSON form
+type testPayload struct {
+	Rule       string                 `json:"rule"`
+	Expression string                 `json:"expression"`
+	Event      testEvent              `json:"event"`
+	Object     map[string]interface{} `json:"object"`
+	OldObject  map[string]interface{} `json:"old_object"`
+}
+
+type testEvent struct {
+	Cluster      string `json:"cluster"`
+	ResourceType string `json:"resource_type"`
+	Action       string `json:"action"`
+	Namespace    string `json:"namespace"`
+	Key          string `json:"key"`
+}
+
+func (h *Handler) AddRoutes(group *echo.Group) {
+	group.GET(shared.EmptyPath, h.getName)
+	group.GET("/rules", h.getRules)
+	group.POST("/test", h.testRule)
+}
+
+func (h *Handler) getName(ctx echo.Context) error {
+	return shared.Responder{Status: http.StatusOK, Success: true, Result: h.Name()}.JSON(ctx)
+}
+
+// Get the rules in use
+func (h *Handler) getRules(ctx echo.Context) error {
+	return shared.Responder{Status: http.StatusOK, Success: true, Result: h.dispatcher.Rules().Rules()}.JSON(ctx)
+}
+
+// Evaluate the rule against the sample event, the compile errors are bad requests
+// while the evaluation errors (e.g. a missing field) are returned in the result
+func (h *Handler) testRule(ctx echo.Context) error {
+	p := new(testPayload)
+	if err := ctx.Bind(p); err != nil {
+		return shared.Responder{Status: http.StatusBadRequest, Success: false, Msg: err}.JSON(ctx)
+	}
+
+	rule, err := h.rule(p)
+	if err != nil {
+		return shared.Responder{Status: http.StatusBadRequest, Success: false, Msg: err}.JSON(ctx)
+	}
+
+	env, err := routing.Environment(&shared.Event{
+		Cluster:      p.Event.Cluster,
+		ResourceType: shared.ResourceType(p.Event.ResourceType),
+		Action:       p.Event.Action,
+		Namespace:    p.Event.Namespace,
+		Key:          p.Event.Key,
+		Object:       p.Object,
+		OldObject:    p.OldObject,
+	})
+	if err != nil {
+		return shared.Responder{Status: http.StatusBadRequest, Success: false, Msg: err}.JSON(ctx)
+	}
+
+	result := map[string]interface{}{"rule": rule.Name, "expression": rule.Expression, "handlers": rule.Handlers}
+	matched, err := rule.Match(env)
+	if err != nil {
+		result["error"] = err.Error()
+	}
+	result["matched"] = matched
+
+	return shared.Responder{Status: http.StatusOK, Success: true, Result: result}.JSON(ctx)
+}
+
+// Returns the configured rule of the name, or compiles the expression
+func (h *Handler) rule(p *testPayload) (*routing.Rule, error) {
+	if p.Expression != "" {
+		return routing.Compile(g.RuleConfig{Name: p.Rule, Expression: p.Expression})
+	}
+
+	for _, rule := range h.dispatcher.Rules().Rules() {
+		if rule.Name == p.Rule {
+			return rule, nil
+		}
+	}
+
+	return nil, errors.New("rule does not exist, give the name of a configured rule or an expression")
+}
@@ -1,10 +1,15 @@
 package sa
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"sync"
 	"time"
 
 	"github.com/srelab/watcher/pkg/handlers/etcd"
+	"go.etcd.io/etcd/clientv3"
 
 	"git.srelab.cn/go/resty"
 
@@ -13,13 +18,29 @@ import (
 	"github.com/srelab/watcher/pkg/handlers/shared"
 )
 
+func init() {
+	shared.Register("sa", func() shared.Handler { return new(Handler) }, "etcd")
+}
+
+// the keys of the etcd handler used to deduplicate the messages
+type keyStore interface {
+	GetKey(ctx context.Context, key string, keysOnly, prefix bool, limit int64) (*clientv3.GetResponse, error)
+	PutKey(ctx context.Context, key, val string, ttl int64) (*clientv3.PutResponse, error)
+	DeleteKey(ctx context.Context, key string, prefix bool) (*clientv3.DeleteResponse, error)
+}
+
 type Handler struct {
 	handlers struct {
-		etcd *etcd.Handler
+		etcd keyStore
 	}
 
+	leaderElection bool
+	logger         log.Logger
+	client         *resty.Client
+
+	// replaced on reloads
+	lock   sync.RWMutex
 	config *g.SAConfig
-	logger log.Logger
 }
 
 func (h *Handler) Name() string        { return "sa" }
@@ -27,10 +48,17 @@ func (h *Handler) RoutePrefix() string { return "/" + h.Name() }
 func (h *Handler) Close()              {}
 
 // The sa handler needs to use the etcd handler to ensure
-// that messages are not sent repeatedly in a clustered environment.
+// that messages are not sent repeatedly in a clustered environment without leader election.
 func (h *Handler) Init(config *g.Configuration, itfs ...interface{}) error {
+	if config.Handlers.SAConfig == nil {
+		return errors.New("sa config is missing")
+	}
+
 	h.config = config.Handlers.SAConfig
+	h.leaderElection = config.LeaderElection.Enable
 	h.logger = log.With("handlers", h.Name())
+	h.client = shared.InstrumentClient(h.Name(), resty.New().
+		SetRetryCount(3).SetRetryWaitTime(5*time.Second).SetRetryMaxWaitTime(20*time.Second))
 
 	for _, itf := range itfs {
 		switch object := itf.(type) {
@@ -42,49 +70,103 @@ func (h *Handler) Init(config *g.Configuration, itfs ...interface{}) error {
 	return nil
 }
 
-func (h *Handler) Created(e *shared.Event) {
-	response, err := h.handlers.etcd.GetKey(e.CacheKey(), true, false, 1)
-	if err == nil && response.Count > 0 {
-		return
+// Reload implements shared.Reloader, the endpoint, the credentials and the notice are replaced
+func (h *Handler) Reload(config *g.Configuration) error {
+	if config.Handlers.SAConfig == nil {
+		return errors.New("sa config is missing")
 	}
 
-	h.handlers.etcd.PutKey(e.CacheKey(), `{"success": true}`, 10)
-	h.send(e.Message())
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.config = config.Handlers.SAConfig
+	return nil
 }
 
-func (h *Handler) Deleted(e *shared.Event) {
-	response, err := h.handlers.etcd.GetKey(e.CacheKey(), true, false, 1)
-	if err == nil && response.Count > 0 {
-		return
+func (h *Handler) getConfig() *g.SAConfig {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	return h.config
+}
+
+func (h *Handler) Created(ctx context.Context, e *shared.Event) error {
+	return h.notify(ctx, e)
+}
+
+func (h *Handler) Deleted(ctx context.Context, e *shared.Event) error {
+	return h.notify(ctx, e)
+}
+
+func (h *Handler) Updated(ctx context.Context, e *shared.Event) error {
+	return h.notify(ctx, e)
+}
+
+// Sends the message of the event once, the key claimed by duplicated is released when the push fails,
+// so the event retried by the dispatcher is sent again instead of being taken as a duplicate
+func (h *Handler) notify(ctx context.Context, e *shared.Event) error {
+	if h.duplicated(ctx, e) {
+		return nil
+	}
+
+	if err := h.send(ctx, e.Message()); err != nil {
+		h.release(ctx, e)
+		return err
 	}
 
-	h.handlers.etcd.PutKey(e.CacheKey(), `{"success": true}`, 10)
-	h.send(e.Message())
+	return nil
 }
 
-func (h *Handler) Updated(e *shared.Event) {
-	response, err := h.handlers.etcd.GetKey(e.CacheKey(), true, false, 1)
+// Returns true when another replica has already sent the message of the event.
+// Only needed without leader election, when every replica handles every event
+func (h *Handler) duplicated(ctx context.Context, e *shared.Event) bool {
+	if h.leaderElection {
+		return false
+	}
+
+	response, err := h.handlers.etcd.GetKey(ctx, e.CacheKey(), true, false, 1)
 	if err == nil && response.Count > 0 {
+		return true
+	}
+
+	h.handlers.etcd.PutKey(ctx, e.CacheKey(), `{"success": true}`, 10)
+	return false
+}
+
+func (h *Handler) release(ctx context.Context, e *shared.Event) {
+	if h.leaderElection {
 		return
 	}
 
-	h.handlers.etcd.PutKey(e.CacheKey(), `{"success": true}`, 10)
-	h.send(e.Message())
+	if _, err := h.handlers.etcd.DeleteKey(ctx, e.CacheKey(), false); err != nil {
+		h.logger.Errorf("release the key of %s error: %s", e.Key, err)
+	}
 }
 
 func (h *Handler) request() *resty.Request {
-	return resty.SetRetryCount(3).SetRetryWaitTime(5 * time.Second).SetRetryMaxWaitTime(20 * time.Second).R()
+	return h.client.R()
 }
 
-func (h *Handler) send(content string) {
-	if !h.config.Notice.Enable {
-		return
+func (h *Handler) send(ctx context.Context, content string) error {
+	config := h.getConfig()
+	if !config.Notice.Enable {
+		return nil
 	}
 
-	h.request().SetHeader("Host", "sa.wolaidai.com").
+	response, err := h.request().SetContext(ctx).SetHeader("Host", "sa.wolaidai.com").
 		SetHeader("Content-Type", "application/json").
-		SetBasicAuth(h.config.Username, h.config.Password).
+		SetBasicAuth(config.Username, config.Password).
 		SetBody(map[string]interface{}{
-			"config": map[string]interface{}{"chat_id": h.config.Notice.ChatID, "content": content},
-		}).Post(fmt.Sprintf("%s/api/tasks/wechat/push", h.config.Endpoint))
+			"config": map[string]interface{}{"chat_id": config.Notice.ChatID, "content": content},
+		}).Post(fmt.Sprintf("%s/api/tasks/wechat/push", config.Endpoint))
+
+	if err != nil {
+		return fmt.Errorf("push message error: %s", err)
+	}
+
+	if response.StatusCode() != http.StatusOK {
+		return fmt.Errorf("push message error, status code[%d]: %s", response.StatusCode(), response.Body())
+	}
+
+	return nil
 }
@@ -0,0 +1,127 @@
+package sa
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"git.srelab.cn/go/resty"
+	"github.com/srelab/common/log"
+	"github.com/srelab/watcher/pkg/g"
+	"github.com/srelab/watcher/pkg/handlers/shared"
+	"go.etcd.io/etcd/clientv3"
+
+	apiV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeKeys keeps the keys in memory, shared by the replicas of a test
+type fakeKeys struct {
+	lock sync.Mutex
+	keys map[string]string
+}
+
+func (f *fakeKeys) GetKey(ctx context.Context, key string, keysOnly, prefix bool, limit int64) (*clientv3.GetResponse, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	response := new(clientv3.GetResponse)
+	if _, ok := f.keys[key]; ok {
+		response.Count = 1
+	}
+
+	return response, nil
+}
+
+func (f *fakeKeys) PutKey(ctx context.Context, key, val string, ttl int64) (*clientv3.PutResponse, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.keys[key] = val
+	return new(clientv3.PutResponse), nil
+}
+
+func (f *fakeKeys) DeleteKey(ctx context.Context, key string, prefix bool) (*clientv3.DeleteResponse, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	delete(f.keys, key)
+	return new(clientv3.DeleteResponse), nil
+}
+
+func TestNotify(t *testing.T) {
+	event := &shared.Event{
+		Key: "default/web", Action: "create", Namespace: "default", Cluster: "east", ResourceType: shared.ResourceTypePod,
+		Object: &apiV1.Pod{ObjectMeta: metaV1.ObjectMeta{Name: "web", Namespace: "default"}},
+	}
+
+	tests := []struct {
+		name           string
+		leaderElection bool
+		// the status of each push, every call is a retry of the same event or the call of another replica
+		statuses []int
+		wantErrs []bool
+		wantSent int
+	}{
+		{
+			name:     "sent once by the replicas",
+			statuses: []int{http.StatusOK, http.StatusOK},
+			wantErrs: []bool{false, false},
+			wantSent: 1,
+		},
+		{
+			name:     "failed push is retried",
+			statuses: []int{http.StatusInternalServerError, http.StatusOK},
+			wantErrs: []bool{true, false},
+			wantSent: 2,
+		},
+		{
+			name:     "push failing every retry",
+			statuses: []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway},
+			wantErrs: []bool{true, true, true},
+			wantSent: 3,
+		},
+		{
+			name:     "no duplicate after the retried push",
+			statuses: []int{http.StatusInternalServerError, http.StatusOK, http.StatusOK},
+			wantErrs: []bool{true, false, false},
+			wantSent: 2,
+		},
+		{
+			name:           "leader election sends every call",
+			leaderElection: true,
+			statuses:       []int{http.StatusInternalServerError, http.StatusOK, http.StatusOK},
+			wantErrs:       []bool{true, false, false},
+			wantSent:       3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statuses[sent])
+				sent++
+			}))
+			defer server.Close()
+
+			config := &g.SAConfig{Endpoint: server.URL}
+			config.Notice.Enable = true
+
+			h := &Handler{config: config, leaderElection: tt.leaderElection, logger: log.With("handlers", "sa"), client: resty.New()}
+			h.handlers.etcd = &fakeKeys{keys: make(map[string]string)}
+
+			for i, wantErr := range tt.wantErrs {
+				if err := h.Created(context.Background(), event); (err != nil) != wantErr {
+					t.Errorf("call %d: Created() error = %v, wantErr %v", i, err, wantErr)
+				}
+			}
+
+			if sent != tt.wantSent {
+				t.Errorf("sent %d messages, want %d", sent, tt.wantSent)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package shared
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Change of a field between OldObject and Object. Path is a JSON pointer, e.g. /spec/replicas,
+// Old is missing for the added fields and New for the removed ones
+type Change struct {
+	Path string      `json:"path"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// ToUnstructured returns the object in its JSON form, nil stays nil
+func ToUnstructured(object interface{}) (map[string]interface{}, error) {
+	switch object := object.(type) {
+	case nil:
+		return nil, nil
+	case *unstructured.Unstructured:
+		return object.UnstructuredContent(), nil
+	case map[string]interface{}:
+		return object, nil
+	}
+
+	return runtime.DefaultUnstructuredConverter.ToUnstructured(object)
+}
+
+// Diff returns the changed fields from old to new, ordered by the path.
+// The lists are compared by the index, so an inserted item changes every item after it
+func Diff(old, new interface{}) ([]Change, error) {
+	oldObject, err := ToUnstructured(old)
+	if err != nil {
+		return nil, err
+	}
+
+	newObject, err := ToUnstructured(new)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make([]Change, 0)
+	diffValues("", oldObject, newObject, &changes)
+
+	return changes, nil
+}
+
+func diffValues(path string, old, new interface{}, changes *[]Change) {
+	switch oldValue := old.(type) {
+	case map[string]interface{}:
+		if newValue, ok := new.(map[string]interface{}); ok {
+			keys := make([]string, 0, len(oldValue)+len(newValue))
+			for key := range oldValue {
+				keys = append(keys, key)
+			}
+
+			for key := range newValue {
+				if _, ok := oldValue[key]; !ok {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+
+			for _, key := range keys {
+				o, inOld := oldValue[key]
+				n, inNew := newValue[key]
+
+				switch keyPath := path + "/" + escapePointer(key); {
+				case !inOld:
+					*changes = append(*changes, Change{Path: keyPath, New: n})
+				case !inNew:
+					*changes = append(*changes, Change{Path: keyPath, Old: o})
+				default:
+					diffValues(keyPath, o, n, changes)
+				}
+			}
+
+			return
+		}
+	case []interface{}:
+		if newValue, ok := new.([]interface{}); ok {
+			for i := 0; i < len(oldValue) || i < len(newValue); i++ {
+				switch indexPath := path + "/" + strconv.Itoa(i); {
+				case i >= len(oldValue):
+					*changes = append(*changes, Change{Path: indexPath, New: newValue[i]})
+				case i >= len(newValue):
+					*changes = append(*changes, Change{Path: indexPath, Old: oldValue[i]})
+				default:
+					diffValues(indexPath, oldValue[i], newValue[i], changes)
+				}
+			}
+
+			return
+		}
+	}
+
+	if !reflect.DeepEqual(old, new) {
+		*changes = append(*changes, Change{Path: path, Old: old, New: new})
+	}
+}
+
+// Escapes a key as a reference token of JSON pointer (RFC 6901)
+func escapePointer(key string) string {
+	return strings.Replace(strings.Replace(key, "~", "~0", -1), "/", "~1", -1)
+}
+
+// IgnoreChanges returns the changes whose path is not under any of the patterns.
+// A pattern is a JSON pointer whose "*" tokens match any token, e.g. /status/conditions/*/lastHeartbeatTime,
+// the changes of the fields under the pointed field are ignored as well
+func IgnoreChanges(changes []Change, patterns []string) []Change {
+	if len(patterns) == 0 {
+		return changes
+	}
+
+	kept := make([]Change, 0, len(changes))
+	for _, change := range changes {
+		ignored := false
+		for _, pattern := range patterns {
+			if matchPointer(pattern, change.Path) {
+				ignored = true
+				break
+			}
+		}
+
+		if !ignored {
+			kept = append(kept, change)
+		}
+	}
+
+	return kept
+}
+
+func matchPointer(pattern, path string) bool {
+	patternTokens := strings.Split(strings.TrimRight(pattern, "/"), "/")
+	pathTokens := strings.Split(path, "/")
+	if len(patternTokens) > len(pathTokens) {
+		return false
+	}
+
+	for i, token := range patternTokens {
+		if token != "*" && token != pathTokens[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,175 @@
+package shared
+
+import (
+	"reflect"
+	"testing"
+
+	apiV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		old  interface{}
+		new  interface{}
+		want []Change
+	}{
+		{
+			name: "equal",
+			old:  map[string]interface{}{"spec": map[string]interface{}{"replicas": 1}},
+			new:  map[string]interface{}{"spec": map[string]interface{}{"replicas": 1}},
+			want: []Change{},
+		},
+		{
+			name: "changed, added and removed fields ordered by the path",
+			old:  map[string]interface{}{"b": 1, "c": "x"},
+			new:  map[string]interface{}{"a": true, "b": 2},
+			want: []Change{{Path: "/a", New: true}, {Path: "/b", Old: 1, New: 2}, {Path: "/c", Old: "x"}},
+		},
+		{
+			name: "lists are compared by the index",
+			old:  map[string]interface{}{"args": []interface{}{"a", "b"}},
+			new:  map[string]interface{}{"args": []interface{}{"x", "a", "b"}},
+			want: []Change{
+				{Path: "/args/0", Old: "a", New: "x"},
+				{Path: "/args/1", Old: "b", New: "a"},
+				{Path: "/args/2", New: "b"},
+			},
+		},
+		{
+			name: "removed list items",
+			old:  map[string]interface{}{"args": []interface{}{"a", "b"}},
+			new:  map[string]interface{}{"args": []interface{}{"a"}},
+			want: []Change{{Path: "/args/1", Old: "b"}},
+		},
+		{
+			name: "type change replaces the value",
+			old:  map[string]interface{}{"value": map[string]interface{}{"a": 1}},
+			new:  map[string]interface{}{"value": "a"},
+			want: []Change{{Path: "/value", Old: map[string]interface{}{"a": 1}, New: "a"}},
+		},
+		{
+			name: "keys are escaped",
+			old:  map[string]interface{}{"annotations": map[string]interface{}{"example.com/a~b": "1"}},
+			new:  map[string]interface{}{"annotations": map[string]interface{}{"example.com/a~b": "2"}},
+			want: []Change{{Path: "/annotations/example.com~1a~0b", Old: "1", New: "2"}},
+		},
+		{
+			name: "nil old object adds every field",
+			old:  nil,
+			new:  map[string]interface{}{"a": 1, "b": map[string]interface{}{"c": 2}},
+			want: []Change{{Path: "/a", New: 1}, {Path: "/b", New: map[string]interface{}{"c": 2}}},
+		},
+		{
+			name: "typed objects",
+			old:  &apiV1.Pod{ObjectMeta: metaV1.ObjectMeta{Name: "web", Labels: map[string]string{"app": "web"}}},
+			new:  &apiV1.Pod{ObjectMeta: metaV1.ObjectMeta{Name: "web", Labels: map[string]string{"app": "api"}}},
+			want: []Change{{Path: "/metadata/labels/app", Old: "web", New: "api"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Diff(tt.old, tt.new)
+			if err != nil {
+				t.Fatalf("Diff() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIgnoreChanges(t *testing.T) {
+	changes := []Change{
+		{Path: "/metadata/resourceVersion"},
+		{Path: "/metadata/annotations/example.com~1revision"},
+		{Path: "/spec/replicas"},
+		{Path: "/status/conditions/0/lastHeartbeatTime"},
+		{Path: "/status/conditions/1/status"},
+	}
+
+	tests := []struct {
+		name     string
+		patterns []string
+		want     []string
+	}{
+		{
+			name:     "no pattern",
+			patterns: nil,
+			want:     []string{"/metadata/resourceVersion", "/metadata/annotations/example.com~1revision", "/spec/replicas", "/status/conditions/0/lastHeartbeatTime", "/status/conditions/1/status"},
+		},
+		{
+			name:     "exact path",
+			patterns: []string{"/metadata/resourceVersion"},
+			want:     []string{"/metadata/annotations/example.com~1revision", "/spec/replicas", "/status/conditions/0/lastHeartbeatTime", "/status/conditions/1/status"},
+		},
+		{
+			name:     "fields under the path",
+			patterns: []string{"/status/"},
+			want:     []string{"/metadata/resourceVersion", "/metadata/annotations/example.com~1revision", "/spec/replicas"},
+		},
+		{
+			name:     "wildcard token",
+			patterns: []string{"/status/conditions/*/lastHeartbeatTime"},
+			want:     []string{"/metadata/resourceVersion", "/metadata/annotations/example.com~1revision", "/spec/replicas", "/status/conditions/1/status"},
+		},
+		{
+			name:     "escaped token",
+			patterns: []string{"/metadata/annotations/example.com~1revision", "/metadata/resourceVersion"},
+			want:     []string{"/spec/replicas", "/status/conditions/0/lastHeartbeatTime", "/status/conditions/1/status"},
+		},
+		{
+			name:     "unescaped token does not match",
+			patterns: []string{"/metadata/annotations/example.com/revision"},
+			want:     []string{"/metadata/resourceVersion", "/metadata/annotations/example.com~1revision", "/spec/replicas", "/status/conditions/0/lastHeartbeatTime", "/status/conditions/1/status"},
+		},
+		{
+			name:     "prefix of a token does not match",
+			patterns: []string{"/spec/replica"},
+			want:     []string{"/metadata/resourceVersion", "/metadata/annotations/example.com~1revision", "/spec/replicas", "/status/conditions/0/lastHeartbeatTime", "/status/conditions/1/status"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make([]string, 0)
+			for _, change := range IgnoreChanges(changes, tt.patterns) {
+				got = append(got, change.Path)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("IgnoreChanges() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchPointer(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{pattern: "/spec", path: "/spec", want: true},
+		{pattern: "/spec", path: "/spec/replicas", want: true},
+		{pattern: "/spec/", path: "/spec/replicas", want: true},
+		{pattern: "/spec/replicas", path: "/spec", want: false},
+		{pattern: "/spec", path: "/specs", want: false},
+		{pattern: "/*/replicas", path: "/spec/replicas", want: true},
+		{pattern: "/*/replicas", path: "/status/readyReplicas", want: false},
+		{pattern: "/metadata/labels/a~1b", path: "/metadata/labels/a~1b", want: true},
+		{pattern: "/metadata/labels/a/b", path: "/metadata/labels/a~1b", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.path, func(t *testing.T) {
+			if got := matchPointer(tt.pattern, tt.path); got != tt.want {
+				t.Errorf("matchPointer(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+			}
+		})
+	}
+}
@@ -9,6 +9,7 @@ import (
 type ServicePayload struct {
 	Name      string `validate:"-" json:"-"`
 	Namespace string `validate:"required" json:"namespace"`
+	Cluster   string `validate:"-" json:"cluster,omitempty"`
 	Host      string `validate:"required,ipv4" json:"host"`
 	Port      int    `validate:"required,min=1,max=65535" json:"port"`
 	Protocol  string `validate:"required" json:"protocol,omitempty"`
@@ -25,9 +26,10 @@ func (s *ServicePayload) String() string {
 	return fmt.Sprintf("%s:%d", s.Host, s.Port)
 }
 
-// Return a legal coredns parsing record
+// Return a legal coredns parsing record, the cluster and namespace of the pod are ignored by coredns,
+// they tell the records written by the watcher from the others
 func (s *ServicePayload) DNSRecord() string {
-	return fmt.Sprintf(`{"host":"%s"}`, s.Host)
+	return fmt.Sprintf(`{"host":"%s","cluster":"%s","namespace":"%s"}`, s.Host, s.Cluster, s.Namespace)
 }
 
 // Return the name of Dns, which consists of FLD and name
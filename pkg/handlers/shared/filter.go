@@ -0,0 +1,106 @@
+package shared
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/srelab/watcher/pkg/g"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Filter selects the events by FilterConfig
+type Filter struct {
+	config      g.FilterConfig
+	labels      labels.Selector
+	annotations labels.Selector
+}
+
+func NewFilter(config g.FilterConfig) (*Filter, error) {
+	f := &Filter{config: config}
+
+	var err error
+	if f.labels, err = labels.Parse(config.Labels); err != nil {
+		return nil, fmt.Errorf("invalid label selector `%s`: %s", config.Labels, err)
+	}
+
+	if f.annotations, err = labels.Parse(config.Annotations); err != nil {
+		return nil, fmt.Errorf("invalid annotation selector `%s`: %s", config.Annotations, err)
+	}
+
+	for _, pattern := range append(config.Namespaces, config.ExcludeNamespaces...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid namespace pattern `%s`: %s", pattern, err)
+		}
+	}
+
+	return f, nil
+}
+
+// Match returns true when the event matches every non-empty rule of the filter.
+// An update matches the selectors when either the old or the new object matches,
+// so the handlers still see an object leaving the selection
+func (f *Filter) Match(event *Event) bool {
+	if !matchAny(f.config.Resources, string(event.ResourceType)) || !matchAny(f.config.Actions, event.Action) {
+		return false
+	}
+
+	if len(f.config.Namespaces) > 0 && !matchGlob(f.config.Namespaces, event.Namespace) {
+		return false
+	}
+
+	if matchGlob(f.config.ExcludeNamespaces, event.Namespace) {
+		return false
+	}
+
+	if f.labels.Empty() && f.annotations.Empty() {
+		return true
+	}
+
+	if f.matchSelectors(event.Object) {
+		return true
+	}
+
+	return event.OldObject != nil && f.matchSelectors(event.OldObject)
+}
+
+// MatchNamespace returns true when the namespace is selected by the namespace rules of the filter
+func (f *Filter) MatchNamespace(namespace string) bool {
+	if len(f.config.Namespaces) > 0 && !matchGlob(f.config.Namespaces, namespace) {
+		return false
+	}
+
+	return !matchGlob(f.config.ExcludeNamespaces, namespace)
+}
+
+func (f *Filter) matchSelectors(object interface{}) bool {
+	objectMeta := (&Event{Object: object}).GetObjectMetaData()
+
+	return f.labels.Matches(labels.Set(objectMeta.Labels)) && f.annotations.Matches(labels.Set(objectMeta.Annotations))
+}
+
+// resource types and actions are written in any case in the config, e.g. pod or Pod
+func matchAny(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns true when the value matches one of the glob patterns
+func matchGlob(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,169 @@
+package shared
+
+import (
+	"testing"
+
+	"github.com/srelab/watcher/pkg/g"
+
+	apiV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func pod(labels, annotations map[string]string) *apiV1.Pod {
+	return &apiV1.Pod{ObjectMeta: metaV1.ObjectMeta{Name: "web", Namespace: "default", Labels: labels, Annotations: annotations}}
+}
+
+func TestNewFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  g.FilterConfig
+		wantErr bool
+	}{
+		{name: "empty", config: g.FilterConfig{}},
+		{name: "valid", config: g.FilterConfig{Labels: "app in (web,api)", Annotations: "team", Namespaces: []string{"kube-*"}}},
+		{name: "invalid labels", config: g.FilterConfig{Labels: "app in (web"}, wantErr: true},
+		{name: "invalid annotations", config: g.FilterConfig{Annotations: "=web"}, wantErr: true},
+		{name: "invalid namespace pattern", config: g.FilterConfig{Namespaces: []string{"kube-["}}, wantErr: true},
+		{name: "invalid excluded namespace pattern", config: g.FilterConfig{ExcludeNamespaces: []string{"["}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewFilter(tt.config); (err != nil) != tt.wantErr {
+				t.Errorf("NewFilter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	web := pod(map[string]string{"app": "web"}, map[string]string{"team": "infra"})
+	api := pod(map[string]string{"app": "api"}, nil)
+
+	tests := []struct {
+		name   string
+		config g.FilterConfig
+		event  *Event
+		want   bool
+	}{
+		{
+			name:   "empty filter matches any event",
+			config: g.FilterConfig{},
+			event:  &Event{Action: "create", ResourceType: ResourceTypePod, Namespace: "default", Object: web},
+			want:   true,
+		},
+		{
+			name:   "resources and actions are case-insensitive",
+			config: g.FilterConfig{Resources: []string{"pod"}, Actions: []string{"CREATE"}},
+			event:  &Event{Action: "create", ResourceType: ResourceTypePod, Object: web},
+			want:   true,
+		},
+		{
+			name:   "other resource",
+			config: g.FilterConfig{Resources: []string{"Service"}},
+			event:  &Event{Action: "create", ResourceType: ResourceTypePod, Object: web},
+			want:   false,
+		},
+		{
+			name:   "other action",
+			config: g.FilterConfig{Actions: []string{"delete"}},
+			event:  &Event{Action: "update", ResourceType: ResourceTypePod, Object: web},
+			want:   false,
+		},
+		{
+			name:   "namespace glob",
+			config: g.FilterConfig{Namespaces: []string{"team-*"}},
+			event:  &Event{Action: "create", ResourceType: ResourceTypePod, Namespace: "team-a", Object: web},
+			want:   true,
+		},
+		{
+			name:   "namespace not selected",
+			config: g.FilterConfig{Namespaces: []string{"team-*"}},
+			event:  &Event{Action: "create", ResourceType: ResourceTypePod, Namespace: "default", Object: web},
+			want:   false,
+		},
+		{
+			name:   "excluded namespace wins over the selected one",
+			config: g.FilterConfig{Namespaces: []string{"*"}, ExcludeNamespaces: []string{"kube-*"}},
+			event:  &Event{Action: "create", ResourceType: ResourceTypePod, Namespace: "kube-system", Object: web},
+			want:   false,
+		},
+		{
+			name:   "labels match",
+			config: g.FilterConfig{Labels: "app=web"},
+			event:  &Event{Action: "create", ResourceType: ResourceTypePod, Object: web},
+			want:   true,
+		},
+		{
+			name:   "labels do not match",
+			config: g.FilterConfig{Labels: "app=web"},
+			event:  &Event{Action: "create", ResourceType: ResourceTypePod, Object: api},
+			want:   false,
+		},
+		{
+			name:   "labels and annotations must both match",
+			config: g.FilterConfig{Labels: "app=web", Annotations: "team=dev"},
+			event:  &Event{Action: "create", ResourceType: ResourceTypePod, Object: web},
+			want:   false,
+		},
+		{
+			name:   "update matches on the new object",
+			config: g.FilterConfig{Labels: "app=web"},
+			event:  &Event{Action: "update", ResourceType: ResourceTypePod, Object: web, OldObject: api},
+			want:   true,
+		},
+		{
+			name:   "update matches only on the old object",
+			config: g.FilterConfig{Labels: "app=web"},
+			event:  &Event{Action: "update", ResourceType: ResourceTypePod, Object: api, OldObject: web},
+			want:   true,
+		},
+		{
+			name:   "update matches on neither object",
+			config: g.FilterConfig{Annotations: "team"},
+			event:  &Event{Action: "update", ResourceType: ResourceTypePod, Object: api, OldObject: api},
+			want:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := NewFilter(tt.config)
+			if err != nil {
+				t.Fatalf("NewFilter() error = %v", err)
+			}
+
+			if got := filter.Match(tt.event); got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterMatchNamespace(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    g.FilterConfig
+		namespace string
+		want      bool
+	}{
+		{name: "no rule", config: g.FilterConfig{}, namespace: "default", want: true},
+		{name: "selected", config: g.FilterConfig{Namespaces: []string{"default"}}, namespace: "default", want: true},
+		{name: "not selected", config: g.FilterConfig{Namespaces: []string{"team-*"}}, namespace: "default", want: false},
+		{name: "excluded", config: g.FilterConfig{ExcludeNamespaces: []string{"kube-*"}}, namespace: "kube-public", want: false},
+		{name: "selectors are ignored", config: g.FilterConfig{Labels: "app=web"}, namespace: "default", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := NewFilter(tt.config)
+			if err != nil {
+				t.Fatalf("NewFilter() error = %v", err)
+			}
+
+			if got := filter.MatchNamespace(tt.namespace); got != tt.want {
+				t.Errorf("MatchNamespace() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
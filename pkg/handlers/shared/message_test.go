@@ -0,0 +1,39 @@
+package shared
+
+import (
+	"strings"
+	"testing"
+
+	appsV1 "k8s.io/api/apps/v1"
+	extV1Beta1 "k8s.io/api/extensions/v1beta1"
+	networkingV1Beta1 "k8s.io/api/networking/v1beta1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMessageKind(t *testing.T) {
+	objectMeta := metaV1.ObjectMeta{Name: "web", Namespace: "default"}
+
+	tests := []struct {
+		name         string
+		object       interface{}
+		resourceType ResourceType
+		want         string
+	}{
+		{name: "apps/v1 daemon set", object: &appsV1.DaemonSet{ObjectMeta: objectMeta}, resourceType: ResourceTypeDaemonSet, want: "daemon set"},
+		{name: "extensions/v1beta1 daemon set", object: &extV1Beta1.DaemonSet{ObjectMeta: objectMeta}, resourceType: ResourceTypeDaemonSet, want: "daemon set"},
+		{name: "apps/v1 replica set", object: &appsV1.ReplicaSet{ObjectMeta: objectMeta}, resourceType: ResourceTypeReplicaSet, want: "replica set"},
+		{name: "extensions/v1beta1 replica set", object: &extV1Beta1.ReplicaSet{ObjectMeta: objectMeta}, resourceType: ResourceTypeReplicaSet, want: "replica set"},
+		{name: "networking/v1beta1 ingress", object: &networkingV1Beta1.Ingress{ObjectMeta: objectMeta}, resourceType: ResourceTypeIngress, want: "ingress"},
+		{name: "extensions/v1beta1 ingress", object: &extV1Beta1.Ingress{ObjectMeta: objectMeta}, resourceType: ResourceTypeIngress, want: "ingress"},
+		{name: "unknown type", object: &appsV1.StatefulSet{ObjectMeta: objectMeta}, resourceType: "StatefulSet", want: "statefulset"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := &Event{Action: "create", Cluster: "east", Namespace: "default", ResourceType: tt.resourceType, Object: tt.object}
+			if got := event.Message(); !strings.Contains(got, "事件类别: "+tt.want+"\n") {
+				t.Errorf("Message() = %q, want the kind %q", got, tt.want)
+			}
+		})
+	}
+}
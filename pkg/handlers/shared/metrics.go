@@ -0,0 +1,60 @@
+package shared
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.srelab.cn/go/resty"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/srelab/watcher/pkg/g"
+)
+
+// prometheus collector
+var promeClientDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: strings.ToLower(g.NAME),
+	Subsystem: "client",
+	Name:      "request_duration_seconds",
+	Help:      "Latency of the requests sent to the external systems, by client, operation and status.",
+	Buckets:   prometheus.DefBuckets,
+}, []string{"client", "operation", "status"})
+
+func init() {
+	prometheus.MustRegister(promeClientDuration)
+}
+
+// ObserveRequest records a request sent by the client since start,
+// status is the HTTP status code, or "ok" and "error" for the other protocols
+func ObserveRequest(client, operation, status string, start time.Time) {
+	promeClientDuration.WithLabelValues(client, operation, status).Observe(time.Since(start).Seconds())
+}
+
+// InstrumentClient records every request sent by the resty client, the retries included.
+// It wraps the transport, so the transport must be configured (e.g. SetTLSClientConfig) before
+func InstrumentClient(name string, client *resty.Client) *resty.Client {
+	return client.SetTransport(&instrumentedTransport{name: name, base: client.GetClient().Transport})
+}
+
+type instrumentedTransport struct {
+	name string
+	base http.RoundTripper
+}
+
+func (t *instrumentedTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	start := time.Now()
+	response, err := base.RoundTrip(request)
+
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(response.StatusCode)
+	}
+
+	ObserveRequest(t.name, request.Method, status, start)
+	return response, err
+}
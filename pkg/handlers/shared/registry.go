@@ -0,0 +1,123 @@
+package shared
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Factory creates a new handler, the handler is initialized by its Init
+type Factory func() Handler
+
+type registration struct {
+	factory      Factory
+	dependencies []string
+}
+
+var (
+	registrations = make(map[string]registration)
+	registryLock  sync.RWMutex
+)
+
+// Register makes the handler available to the config, it is called by the init of the handler package.
+// dependencies are the names of the handlers passed to its Init, they are initialized before it
+func Register(name string, factory Factory, dependencies ...string) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if _, ok := registrations[name]; ok {
+		panic(fmt.Sprintf("handler[%s] is registered twice", name))
+	}
+
+	registrations[name] = registration{factory: factory, dependencies: dependencies}
+}
+
+// Registered returns the names of all the registered handlers
+func Registered() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	return registeredNames()
+}
+
+// Dependencies returns the names of the handlers the handler depends on
+func Dependencies(name string) []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	return registrations[name].dependencies
+}
+
+// NewHandlers creates the enabled handlers, every handler comes after its dependencies.
+// All the registered handlers are enabled when the list is empty, an error names the
+// handler that is not registered, or the dependency that is not enabled
+func NewHandlers(enabled []string) (Handlers, error) {
+	if len(enabled) == 0 {
+		enabled = Registered()
+	}
+
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	selected := make(map[string]bool)
+	for _, name := range enabled {
+		if _, ok := registrations[name]; !ok {
+			return nil, fmt.Errorf("handler[%s] is not registered, the handlers are: %s", name, strings.Join(registeredNames(), ", "))
+		}
+		selected[name] = true
+	}
+
+	for _, name := range enabled {
+		for _, dependency := range registrations[name].dependencies {
+			if !selected[dependency] {
+				return nil, fmt.Errorf("handler[%s] depends on handler[%s], which is not enabled", name, dependency)
+			}
+		}
+	}
+
+	var (
+		handlers = make(Handlers, 0, len(enabled))
+		state    = make(map[string]int) // 1: visiting, 2: added
+		visit    func(name string, path []string) error
+	)
+
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("handlers depend on each other: %s", strings.Join(append(path, name), " -> "))
+		case 2:
+			return nil
+		}
+
+		state[name] = 1
+		for _, dependency := range registrations[name].dependencies {
+			if err := visit(dependency, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+
+		handlers = append(handlers, registrations[name].factory())
+		return nil
+	}
+
+	for _, name := range enabled {
+		if err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return handlers, nil
+}
+
+// registryLock must be held
+func registeredNames() []string {
+	names := make([]string, 0, len(registrations))
+	for name := range registrations {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,129 @@
+package shared
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo"
+	"github.com/srelab/watcher/pkg/g"
+)
+
+type fakeHandler struct{ name string }
+
+func (h *fakeHandler) Name() string                                            { return h.name }
+func (h *fakeHandler) RoutePrefix() string                                     { return "/" + h.name }
+func (h *fakeHandler) Init(config *g.Configuration, itfs ...interface{}) error { return nil }
+func (h *fakeHandler) Created(ctx context.Context, event *Event) error         { return nil }
+func (h *fakeHandler) Deleted(ctx context.Context, event *Event) error         { return nil }
+func (h *fakeHandler) Updated(ctx context.Context, event *Event) error         { return nil }
+func (h *fakeHandler) Close()                                                  {}
+func (h *fakeHandler) AddRoutes(group *echo.Group)                             {}
+
+func TestNewHandlers(t *testing.T) {
+	tests := []struct {
+		name         string
+		dependencies map[string][]string
+		enabled      []string
+		want         []string
+		wantErr      string
+	}{
+		{
+			name:         "no dependency keeps the order",
+			dependencies: map[string][]string{"a": nil, "b": nil},
+			enabled:      []string{"b", "a"},
+			want:         []string{"b", "a"},
+		},
+		{
+			name:         "dependencies come first",
+			dependencies: map[string][]string{"etcd": nil, "gateway": {"etcd"}, "sa": {"gateway", "etcd"}},
+			enabled:      []string{"sa", "gateway", "etcd"},
+			want:         []string{"etcd", "gateway", "sa"},
+		},
+		{
+			name:         "shared dependency is created once",
+			dependencies: map[string][]string{"a": {"c"}, "b": {"c"}, "c": nil},
+			enabled:      []string{"a", "b", "c"},
+			want:         []string{"c", "a", "b"},
+		},
+		{
+			name:         "empty list enables every handler",
+			dependencies: map[string][]string{"b": {"a"}, "a": nil},
+			enabled:      nil,
+			want:         []string{"a", "b"},
+		},
+		{
+			name:         "handler not registered",
+			dependencies: map[string][]string{"a": nil},
+			enabled:      []string{"a", "x"},
+			wantErr:      "handler[x] is not registered",
+		},
+		{
+			name:         "dependency not enabled",
+			dependencies: map[string][]string{"a": nil, "b": {"a"}},
+			enabled:      []string{"b"},
+			wantErr:      "handler[b] depends on handler[a], which is not enabled",
+		},
+		{
+			name:         "dependency cycle",
+			dependencies: map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"a"}},
+			enabled:      []string{"a", "b", "c"},
+			wantErr:      "handlers depend on each other: a -> b -> c -> a",
+		},
+		{
+			name:         "handler depending on itself",
+			dependencies: map[string][]string{"a": {"a"}},
+			enabled:      []string{"a"},
+			wantErr:      "handlers depend on each other: a -> a",
+		},
+	}
+
+	defer func(saved map[string]registration) { registrations = saved }(registrations)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registrations = make(map[string]registration)
+			for name, dependencies := range tt.dependencies {
+				name := name
+				Register(name, func() Handler { return &fakeHandler{name: name} }, dependencies...)
+			}
+
+			handlers, err := NewHandlers(tt.enabled)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("NewHandlers() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("NewHandlers() error = %v", err)
+			}
+
+			got := make([]string, 0, len(handlers))
+			for _, handler := range handlers {
+				got = append(got, handler.Name())
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewHandlers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	defer func(saved map[string]registration) { registrations = saved }(registrations)
+	registrations = make(map[string]registration)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Register() did not panic on the second registration")
+		}
+	}()
+
+	factory := func() Handler { return &fakeHandler{name: "a"} }
+	Register("a", factory)
+	Register("a", factory)
+}
@@ -1,10 +1,12 @@
 package shared
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -16,7 +18,10 @@ import (
 	appsV1 "k8s.io/api/apps/v1"
 	batchV1 "k8s.io/api/batch/v1"
 	apiV1 "k8s.io/api/core/v1"
+	eventsV1Beta1 "k8s.io/api/events/v1beta1"
 	extV1Beta1 "k8s.io/api/extensions/v1beta1"
+	networkingV1Beta1 "k8s.io/api/networking/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/labstack/echo"
@@ -55,6 +60,11 @@ func (d *Datetime) UnmarshalJSON(b []byte) (err error) {
 	return
 }
 
+// UnmarshalParam makes Datetime usable in the query params bound by echo
+func (d *Datetime) UnmarshalParam(param string) error {
+	return d.UnmarshalJSON([]byte(param))
+}
+
 func (d *Datetime) MarshalJSON() ([]byte, error) {
 	if d.Time.UnixNano() == (time.Time{}).UnixNano() {
 		return []byte("null"), nil
@@ -67,20 +77,52 @@ func (d *Datetime) MarshalJSON() ([]byte, error) {
 const EmptyPath = ""
 
 // Handler is implemented by any handler.
-// The Handle method is used to process event
+// Created, Deleted and Updated are used to process event, the event is retried
+// with the same handler when an error is returned, other handlers are not affected
 type Handler interface {
 	Name() string
 	RoutePrefix() string
 
 	Init(config *g.Configuration, itfs ...interface{}) error
-	Created(event *Event)
-	Deleted(event *Event)
-	Updated(event *Event)
+	Created(ctx context.Context, event *Event) error
+	Deleted(ctx context.Context, event *Event) error
+	Updated(ctx context.Context, event *Event) error
 	Close()
 
 	AddRoutes(group *echo.Group)
 }
 
+// Reconciler is implemented by the handlers that keep an external system in sync with the clusters.
+// Reconcile is called on the leader once the informers are synced, to catch up with the changes
+// that happened while no watcher was running, e.g. the pods created or deleted during a restart
+type Reconciler interface {
+	Reconcile(ctx context.Context, lister Lister) error
+}
+
+// HealthChecker is implemented by the components checked by the readiness probe,
+// e.g. the handlers check that their backend is reachable
+type HealthChecker interface {
+	Check(ctx context.Context) error
+}
+
+// Reloader is implemented by the handlers that apply a reloaded configuration without a restart,
+// the settings the handler cannot change at runtime are kept until the next start
+type Reloader interface {
+	Reload(config *g.Configuration) error
+}
+
+// Passive is implemented by the handlers that do not process the events, e.g. they observe the
+// dispatcher instead; the dispatcher gives them no queue and the rules cannot target them
+type Passive interface {
+	Passive() bool
+}
+
+// Lister lists the objects cached by the informers of every cluster, each as a "create" event.
+// An error is returned when the resource type is not watched in a cluster or its informer has not synced
+type Lister interface {
+	List(resourceType ResourceType) ([]*Event, error)
+}
+
 // Store the slice of the handler
 type Handlers []Handler
 
@@ -132,6 +174,7 @@ const (
 	ResourceTypeSecret                ResourceType = "Secret"
 	ResourceTypeConfigMap             ResourceType = "ConfigMap"
 	ResourceTypeIngress               ResourceType = "Ingress"
+	ResourceTypeEvent                 ResourceType = "Event"
 )
 
 // Event indicate the informerEvent
@@ -142,6 +185,35 @@ type Event struct {
 	Action       string
 	Namespace    string
 	ResourceType ResourceType
+
+	// name of the cluster where the event happened
+	Cluster string
+
+	// the changes from OldObject to Object of an update, without the ignored paths
+	Diff []Change
+
+	// the controllers of the object, from its direct owner to the top-level workload,
+	// e.g. the ReplicaSet then the Deployment of a pod
+	Owners []Owner
+
+	// the labels and annotations selected by Kubernetes.Owners, e.g. team
+	Labels map[string]string
+}
+
+// Owner is a controller of an object, resolved from the owner references
+type Owner struct {
+	APIVersion string `json:"api_version"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+}
+
+// Workload returns the top-level owner of the object, the object itself when it has no owner
+func (event *Event) Workload() Owner {
+	if len(event.Owners) > 0 {
+		return event.Owners[len(event.Owners)-1]
+	}
+
+	return Owner{Kind: string(event.ResourceType), Name: event.GetObjectMetaData().Name}
 }
 
 // Return a set of services from the pod's Containers
@@ -203,6 +275,7 @@ func (event *Event) GetPodServices(pod *apiV1.Pod) ([]*ServicePayload, error) {
 		}
 
 		service.Namespace = event.Namespace
+		service.Cluster = event.Cluster
 		if err := validator.New().Struct(service); err != nil {
 			if service.Name == "" {
 				service.Name = container.Name
@@ -217,46 +290,115 @@ func (event *Event) GetPodServices(pod *apiV1.Pod) ([]*ServicePayload, error) {
 	return services, nil
 }
 
-// GetObjectMetaData returns metadata of a given k8s object
+// PodReady returns true when the pod can serve traffic,
+// its Ready condition is true, it has an IP and it is not terminating
+func PodReady(pod *apiV1.Pod) bool {
+	if pod == nil || pod.Status.PodIP == "" || pod.DeletionTimestamp != nil {
+		return false
+	}
+
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == apiV1.PodReady {
+			return condition.Status == apiV1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+// PodTransition compares the pod before (OldObject) and after (Object) a create or update event.
+// The services are registered when the pod becomes ready, and deregistered when it becomes NotReady,
+// starts terminating or changes the IP. Both are empty when the readiness and IP are unchanged
+func (event *Event) PodTransition() (register, deregister []*ServicePayload, err error) {
+	pod, ok := event.Object.(*apiV1.Pod)
+	if !ok {
+		return nil, nil, nil
+	}
+
+	oldPod, _ := event.OldObject.(*apiV1.Pod)
+
+	ready, oldReady := PodReady(pod), PodReady(oldPod)
+	ipChanged := oldPod != nil && oldPod.Status.PodIP != pod.Status.PodIP
+
+	if oldReady && (!ready || ipChanged) {
+		if deregister, err = event.GetPodServices(oldPod); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if ready && (!oldReady || ipChanged) {
+		if register, err = event.GetPodServices(pod); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return register, deregister, nil
+}
+
+// GetObjectMetaData returns metadata of a given k8s object,
+// both the typed objects and the unstructured objects of custom resources are supported
 func (event *Event) GetObjectMetaData() metaV1.ObjectMeta {
 	var objectMeta metaV1.ObjectMeta
 
-	switch object := event.Object.(type) {
-	case *appsV1.Deployment:
-		objectMeta = object.ObjectMeta
-	case *apiV1.ReplicationController:
-		objectMeta = object.ObjectMeta
-	case *appsV1.ReplicaSet:
-		objectMeta = object.ObjectMeta
-	case *appsV1.DaemonSet:
-		objectMeta = object.ObjectMeta
-	case *apiV1.Service:
-		objectMeta = object.ObjectMeta
-	case *apiV1.Pod:
-		objectMeta = object.ObjectMeta
-	case *batchV1.Job:
-		objectMeta = object.ObjectMeta
-	case *apiV1.PersistentVolume:
-		objectMeta = object.ObjectMeta
-	case *apiV1.Namespace:
-		objectMeta = object.ObjectMeta
-	case *apiV1.Secret:
-		objectMeta = object.ObjectMeta
-	case *extV1Beta1.Ingress:
-		objectMeta = object.ObjectMeta
+	accessor, err := meta.Accessor(event.Object)
+	if err != nil {
+		return objectMeta
+	}
+
+	if object, ok := accessor.(*metaV1.ObjectMeta); ok {
+		return *object
 	}
 
+	objectMeta.Name = accessor.GetName()
+	objectMeta.Namespace = accessor.GetNamespace()
+	objectMeta.UID = accessor.GetUID()
+	objectMeta.ResourceVersion = accessor.GetResourceVersion()
+	objectMeta.Generation = accessor.GetGeneration()
+	objectMeta.CreationTimestamp = accessor.GetCreationTimestamp()
+	objectMeta.DeletionTimestamp = accessor.GetDeletionTimestamp()
+	objectMeta.Labels = accessor.GetLabels()
+	objectMeta.Annotations = accessor.GetAnnotations()
+	objectMeta.OwnerReferences = accessor.GetOwnerReferences()
+
 	return objectMeta
 }
 
+// CoreEvent returns the Kubernetes Event in its core/v1 form, the events.k8s.io Events are converted,
+// false when the object is not a Kubernetes Event
+func CoreEvent(object interface{}) (*apiV1.Event, bool) {
+	switch kubeEvent := object.(type) {
+	case *apiV1.Event:
+		return kubeEvent, true
+	case *eventsV1Beta1.Event:
+		coreEvent := &apiV1.Event{
+			ObjectMeta:     kubeEvent.ObjectMeta,
+			InvolvedObject: kubeEvent.Regarding,
+			Reason:         kubeEvent.Reason,
+			Message:        kubeEvent.Note,
+			Type:           kubeEvent.Type,
+			Count:          kubeEvent.DeprecatedCount,
+		}
+
+		if kubeEvent.Series != nil {
+			coreEvent.Series = &apiV1.EventSeries{Count: kubeEvent.Series.Count}
+		}
+
+		return coreEvent, true
+	}
+
+	return nil, false
+}
+
 // Message returns event message in standard format.
 // included as a part of event packege to enhance code resuablity across handlers.
 func (event *Event) Message() (msg string) {
 	var kind string
 
 	objectMeta := event.GetObjectMetaData()
+
+	// the objects are typed by the version of the configured resource, e.g. apps/v1 or extensions/v1beta1
 	switch event.Object.(type) {
-	case *extV1Beta1.DaemonSet:
+	case *appsV1.DaemonSet, *extV1Beta1.DaemonSet:
 		kind = "daemon set"
 	case *appsV1.Deployment:
 		kind = "deployment"
@@ -264,7 +406,7 @@ func (event *Event) Message() (msg string) {
 		kind = "job"
 	case *apiV1.Namespace:
 		kind = "namespace"
-	case *extV1Beta1.Ingress:
+	case *networkingV1Beta1.Ingress, *extV1Beta1.Ingress:
 		kind = "ingress"
 	case *apiV1.PersistentVolume:
 		kind = "persistent volume"
@@ -272,7 +414,7 @@ func (event *Event) Message() (msg string) {
 		kind = "pod"
 	case *apiV1.ReplicationController:
 		kind = "replication controller"
-	case *extV1Beta1.ReplicaSet:
+	case *appsV1.ReplicaSet, *extV1Beta1.ReplicaSet:
 		kind = "replica set"
 	case *apiV1.Service:
 		kind = "service"
@@ -280,33 +422,89 @@ func (event *Event) Message() (msg string) {
 		kind = "secret"
 	case *apiV1.ConfigMap:
 		kind = "configmap"
+	case *apiV1.Event, *eventsV1Beta1.Event:
+		kind = "event"
+	default:
+		kind = strings.ToLower(string(event.ResourceType))
 	}
 
 	switch kind {
+	case "event":
+		kubeEvent, _ := CoreEvent(event.Object)
+		count := kubeEvent.Count
+		if kubeEvent.Series != nil {
+			count = kubeEvent.Series.Count
+		}
+		if count == 0 {
+			count = 1
+		}
+
+		msg = fmt.Sprintf(
+			"Kubernetes 集群事件\n"+
+				"集群名称: %s\n"+
+				"事件类别: %s event\n"+
+				"命名空间: %s\n"+
+				"涉及对象: %s/%s\n"+
+				"事件原因: %s\n"+
+				"事件描述: %s\n"+
+				"发生次数: %d\n",
+			event.Cluster,
+			strings.ToLower(kubeEvent.Type),
+			kubeEvent.InvolvedObject.Namespace,
+			kubeEvent.InvolvedObject.Kind,
+			kubeEvent.InvolvedObject.Name,
+			kubeEvent.Reason,
+			kubeEvent.Message,
+			count,
+		)
 	case "namespace":
 		msg = fmt.Sprintf(
 			"Kubernetes 集群事件\n"+
+				"集群名称: %s\n"+
 				"事件类别: namespace\n"+
 				"事件描述: %s has been %s\n",
+			event.Cluster,
 			objectMeta.Name,
 			event.Action,
 		)
 	default:
 		msg = fmt.Sprintf(
 			"Kubernetes 集群事件\n"+
+				"集群名称: %s\n"+
 				"事件类别: %s\n"+
 				"命名空间: %s\n"+
 				"事件描述: %s has been %s\n",
+			event.Cluster,
 			kind,
 			event.Namespace,
 			objectMeta.Name,
 			event.Action,
 		)
+
+		if len(event.Owners) > 0 {
+			workload := event.Workload()
+			msg += fmt.Sprintf("所属负载: %s/%s\n", workload.Kind, workload.Name)
+		}
+	}
+
+	for _, key := range sortedKeys(event.Labels) {
+		msg += fmt.Sprintf("%s: %s\n", key, event.Labels[key])
 	}
 
 	return msg
 }
 
+// Returns the keys of the map in order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
 func (event *Event) CacheKey() string {
-	return path.Join("/watcher/handlers/etcd/", event.Key, event.Action)
+	return path.Join("/watcher/handlers/etcd/", event.Cluster, event.Key, event.Action)
 }
@@ -0,0 +1,116 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/srelab/watcher/pkg/handlers/shared"
+)
+
+// prefix of the CloudEvents type attribute, e.g. io.srelab.watcher.pod.create
+const cloudEventTypePrefix = "io.srelab.watcher"
+
+// Encoder turns the event into the body sent to the sink
+type Encoder interface {
+	Encode(event *shared.Event) ([]byte, error)
+	ContentType() string
+}
+
+// Returns the encoder of the format, json is the default
+func NewEncoder(format string) (Encoder, error) {
+	switch format {
+	case "", "json":
+		return jsonEncoder{}, nil
+	case "cloudevents":
+		return cloudEventsEncoder{}, nil
+	}
+
+	return nil, fmt.Errorf("invalid sink format `%s`", format)
+}
+
+type jsonEvent struct {
+	Cluster      string              `json:"cluster"`
+	ResourceType shared.ResourceType `json:"resource_type"`
+	Action       string              `json:"action"`
+	Namespace    string              `json:"namespace"`
+	Key          string              `json:"key"`
+	Time         *shared.Datetime    `json:"time"`
+	Object       interface{}         `json:"object"`
+	OldObject    interface{}         `json:"old_object,omitempty"`
+	Diff         []shared.Change     `json:"diff,omitempty"`
+	Owners       []shared.Owner      `json:"owners,omitempty"`
+	Labels       map[string]string   `json:"labels,omitempty"`
+}
+
+type jsonEncoder struct{}
+
+func (jsonEncoder) ContentType() string { return "application/json" }
+
+func (jsonEncoder) Encode(event *shared.Event) ([]byte, error) {
+	return json.Marshal(&jsonEvent{
+		Cluster:      event.Cluster,
+		ResourceType: event.ResourceType,
+		Action:       event.Action,
+		Namespace:    event.Namespace,
+		Key:          event.Key,
+		Time:         &shared.Datetime{Time: time.Now()},
+		Object:       event.Object,
+		OldObject:    event.OldObject,
+		Diff:         event.Diff,
+		Owners:       event.Owners,
+		Labels:       event.Labels,
+	})
+}
+
+// CloudEvents 1.0 in the structured content mode, cluster and namespace are extension attributes
+type cloudEvent struct {
+	SpecVersion     string         `json:"specversion"`
+	ID              string         `json:"id"`
+	Source          string         `json:"source"`
+	Type            string         `json:"type"`
+	Subject         string         `json:"subject,omitempty"`
+	Time            string         `json:"time"`
+	DataContentType string         `json:"datacontenttype"`
+	Data            cloudEventData `json:"data"`
+	Cluster         string         `json:"cluster"`
+	Namespace       string         `json:"namespace,omitempty"`
+}
+
+type cloudEventData struct {
+	Object    interface{}       `json:"object"`
+	OldObject interface{}       `json:"old_object,omitempty"`
+	Diff      []shared.Change   `json:"diff,omitempty"`
+	Owners    []shared.Owner    `json:"owners,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty"`
+}
+
+type cloudEventsEncoder struct{}
+
+func (cloudEventsEncoder) ContentType() string { return "application/cloudevents+json" }
+
+// The id is made of the uid and resourceVersion of the object, so the retries of an event keep the same id
+func (cloudEventsEncoder) Encode(event *shared.Event) ([]byte, error) {
+	objectMeta := event.GetObjectMetaData()
+	data := cloudEventData{
+		Object:    event.Object,
+		OldObject: event.OldObject,
+		Diff:      event.Diff,
+		Owners:    event.Owners,
+		Labels:    event.Labels,
+	}
+
+	return json.Marshal(&cloudEvent{
+		SpecVersion:     "1.0",
+		ID:              fmt.Sprintf("%s-%s-%s", objectMeta.UID, objectMeta.ResourceVersion, event.Action),
+		Source:          "/watcher/clusters/" + event.Cluster,
+		Type:            fmt.Sprintf("%s.%s.%s", cloudEventTypePrefix, strings.ToLower(string(event.ResourceType)), event.Action),
+		Subject:         event.Key,
+		Time:            time.Now().UTC().Format(time.RFC3339Nano),
+		DataContentType: "application/json",
+		Data:            data,
+		Cluster:         event.Cluster,
+		Namespace:       event.Namespace,
+	})
+}
@@ -0,0 +1,47 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/natefinch/lumberjack"
+	"github.com/srelab/watcher/pkg/g"
+)
+
+// File appends the events to a JSONL file, one event per line, rotated by lumberjack
+type File struct {
+	lock   sync.Mutex
+	logger *lumberjack.Logger
+}
+
+func NewFile(config g.SinkConfig) (*File, error) {
+	if config.Path == "" {
+		return nil, errors.New("file path is required")
+	}
+
+	return &File{logger: &lumberjack.Logger{
+		Filename:   config.Path,
+		MaxSize:    config.MaxSize,
+		MaxBackups: config.MaxBackups,
+		MaxAge:     config.MaxAge,
+		Compress:   config.Compress,
+		LocalTime:  true,
+	}}, nil
+}
+
+// The line is written in one call, so a rotation never splits an event
+func (f *File) Send(ctx context.Context, contentType string, body []byte) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	_, err := f.logger.Write(append(body, '\n'))
+	return err
+}
+
+func (f *File) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.logger.Close()
+}
@@ -0,0 +1,114 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/srelab/common/log"
+	"github.com/srelab/watcher/pkg/g"
+	"github.com/srelab/watcher/pkg/handlers/shared"
+)
+
+// Sink writes the encoded events to an external system
+type Sink interface {
+	Send(ctx context.Context, contentType string, body []byte) error
+	Close() error
+}
+
+// Handler sends the events matched by the filter to its sink, every configured sink is a handler,
+// so each sink has its own queue, retries and dead letters
+type Handler struct {
+	config  g.SinkConfig
+	filter  *shared.Filter
+	encoder Encoder
+	sink    Sink
+	logger  log.Logger
+}
+
+// Handlers returns a handler for every sink config
+func Handlers(configs []g.SinkConfig) shared.Handlers {
+	handlers := make(shared.Handlers, 0, len(configs))
+	for _, config := range configs {
+		handlers = append(handlers, New(config))
+	}
+
+	return handlers
+}
+
+func New(config g.SinkConfig) *Handler {
+	return &Handler{config: config}
+}
+
+func (h *Handler) Name() string        { return "sink/" + h.config.Name }
+func (h *Handler) RoutePrefix() string { return "/" + h.Name() }
+
+func (h *Handler) Close() {
+	if h.sink == nil {
+		return
+	}
+
+	if err := h.sink.Close(); err != nil {
+		h.logger.Errorf("close sink error: %s", err)
+	}
+}
+
+// Initialize the encoder and the sink of the config
+func (h *Handler) Init(config *g.Configuration, itfs ...interface{}) error {
+	h.logger = log.With("handlers", h.Name())
+
+	if h.config.Name == "" {
+		return errors.New("sink name is required")
+	}
+
+	filter, err := shared.NewFilter(h.config.Filter)
+	if err != nil {
+		return err
+	}
+	h.filter = filter
+
+	encoder, err := NewEncoder(h.config.Format)
+	if err != nil {
+		return err
+	}
+	h.encoder = encoder
+
+	switch h.config.Type {
+	case "webhook":
+		h.sink, err = NewWebhook(h.config)
+	case "file":
+		h.sink, err = NewFile(h.config)
+	default:
+		err = fmt.Errorf("invalid sink type `%s`", h.config.Type)
+	}
+
+	return err
+}
+
+func (h *Handler) Created(ctx context.Context, e *shared.Event) error { return h.send(ctx, e) }
+func (h *Handler) Deleted(ctx context.Context, e *shared.Event) error { return h.send(ctx, e) }
+func (h *Handler) Updated(ctx context.Context, e *shared.Event) error { return h.send(ctx, e) }
+
+func (h *Handler) send(ctx context.Context, e *shared.Event) error {
+	if !h.filter.Match(e) {
+		return nil
+	}
+
+	body, err := h.encoder.Encode(e)
+	if err != nil {
+		h.logger.Errorf("encode %s error: %s", e.Key, err)
+		return nil
+	}
+
+	return h.sink.Send(ctx, h.encoder.ContentType(), body)
+}
+
+func (h *Handler) AddRoutes(group *echo.Group) {
+	group.GET(shared.EmptyPath, h.getName)
+}
+
+func (h *Handler) getName(ctx echo.Context) error {
+	return shared.Responder{Status: http.StatusOK, Success: true, Result: h.Name()}.JSON(ctx)
+}
@@ -0,0 +1,74 @@
+package sink
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"git.srelab.cn/go/resty"
+	"github.com/srelab/watcher/pkg/g"
+	"github.com/srelab/watcher/pkg/handlers/shared"
+)
+
+// header of the HMAC-SHA256 signature of the body, in the form of sha256=<hex>
+const signatureHeader = "X-Watcher-Signature"
+
+// Webhook posts the events to an HTTP endpoint,
+// the requests failed by network errors, 429 or 5xx responses are retried
+type Webhook struct {
+	config g.SinkConfig
+	client *resty.Client
+}
+
+func NewWebhook(config g.SinkConfig) (*Webhook, error) {
+	if config.URL == "" {
+		return nil, errors.New("webhook url is required")
+	}
+
+	timeout := config.Timeout * time.Second
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+
+	client := resty.New().
+		SetTimeout(timeout).
+		SetRetryCount(config.Retries).
+		SetRetryWaitTime(time.Second).
+		SetRetryMaxWaitTime(10 * time.Second).
+		AddRetryCondition(func(response *resty.Response) (bool, error) {
+			if response == nil {
+				return true, nil
+			}
+
+			return response.StatusCode() == http.StatusTooManyRequests || response.StatusCode() >= 500, nil
+		})
+
+	return &Webhook{config: config, client: shared.InstrumentClient("sink/"+config.Name, client)}, nil
+}
+
+func (w *Webhook) Send(ctx context.Context, contentType string, body []byte) error {
+	request := w.client.R().SetContext(ctx).SetHeader("Content-Type", contentType).SetBody(body)
+	if w.config.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.config.Secret))
+		mac.Write(body)
+		request.SetHeader(signatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	response, err := request.Post(w.config.URL)
+	if err != nil {
+		return fmt.Errorf("post to %s error: %s", w.config.URL, err)
+	}
+
+	if response.StatusCode() < 200 || response.StatusCode() >= 300 {
+		return fmt.Errorf("post to %s error, status code[%d]: %s", w.config.URL, response.StatusCode(), response.Body())
+	}
+
+	return nil
+}
+
+func (w *Webhook) Close() error { return nil }
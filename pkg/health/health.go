@@ -0,0 +1,76 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/srelab/watcher/pkg/handlers/shared"
+)
+
+// every check of the readiness is given at most checkTimeout
+const checkTimeout = 5 * time.Second
+
+// Health runs the checks of the components for the readiness probe,
+// the replica is ready when every component is healthy
+type Health struct {
+	names    []string
+	checkers map[string]shared.HealthChecker
+}
+
+func New() *Health {
+	return &Health{checkers: make(map[string]shared.HealthChecker)}
+}
+
+// Add adds the checker of the component, it must be added before the probes are served
+func (h *Health) Add(name string, checker shared.HealthChecker) {
+	if _, ok := h.checkers[name]; !ok {
+		h.names = append(h.names, name)
+	}
+
+	h.checkers[name] = checker
+}
+
+// Status is the result of the check of a component
+type Status struct {
+	Healthy  bool    `json:"healthy"`
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"duration"`
+}
+
+// Check runs the checks of all the components concurrently, false is returned when any of them fails
+func (h *Health) Check(ctx context.Context) (map[string]Status, bool) {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	var (
+		lock    sync.Mutex
+		wg      sync.WaitGroup
+		healthy = true
+		result  = make(map[string]Status, len(h.names))
+	)
+
+	for _, name := range h.names {
+		wg.Add(1)
+		go func(name string, checker shared.HealthChecker) {
+			defer wg.Done()
+
+			start := time.Now()
+			err := checker.Check(ctx)
+
+			status := Status{Healthy: err == nil, Duration: time.Since(start).Seconds()}
+			if err != nil {
+				status.Error = err.Error()
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			result[name] = status
+			healthy = healthy && status.Healthy
+		}(name, h.checkers[name])
+	}
+
+	wg.Wait()
+	return result, healthy
+}
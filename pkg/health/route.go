@@ -0,0 +1,24 @@
+package health
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo"
+	"github.com/srelab/watcher/pkg/handlers/shared"
+)
+
+// The liveness only tells the process is serving, the backends are not checked,
+// so a broken backend makes the replica unready instead of restarting it
+func (h *Health) GetLiveness(ctx echo.Context) error {
+	return shared.Responder{Status: http.StatusOK, Success: true, Result: "ok"}.JSON(ctx)
+}
+
+// Returns the status of every component, 503 when any of them is unhealthy
+func (h *Health) GetReadiness(ctx echo.Context) error {
+	result, healthy := h.Check(ctx.Request().Context())
+	if !healthy {
+		return shared.Responder{Status: http.StatusServiceUnavailable, Success: false, Result: result}.JSON(ctx)
+	}
+
+	return shared.Responder{Status: http.StatusOK, Success: true, Result: result}.JSON(ctx)
+}
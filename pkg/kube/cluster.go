@@ -0,0 +1,142 @@
+package kube
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/srelab/watcher/pkg/g"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Cluster holds the clients of a watched kubernetes cluster
+type Cluster struct {
+	Name string
+
+	Client  kubernetes.Interface
+	Dynamic dynamic.Interface
+
+	// built from the api resources discovered at startup,
+	// CRDs installed after the watcher started are not known to it
+	Mapper meta.RESTMapper
+}
+
+// Store the slice of the cluster, the first one is the default cluster
+type Clusters []*Cluster
+
+// Returns the cluster by name, nil when it does not exist
+func (clusters Clusters) Get(name string) *Cluster {
+	for _, cluster := range clusters {
+		if cluster.Name == name {
+			return cluster
+		}
+	}
+
+	return nil
+}
+
+// Returns the names of all clusters
+func (clusters Clusters) Names() []string {
+	names := make([]string, 0)
+	for _, cluster := range clusters {
+		names = append(names, cluster.Name)
+	}
+
+	return names
+}
+
+// NewClusters creates the clients of every configured cluster
+func NewClusters(config *g.Kubernetes) (Clusters, error) {
+	// Without any cluster configured, the cluster described by Config is watched,
+	// using the in-cluster config when running inside of a pod
+	clusterConfigs := config.Clusters
+	if len(clusterConfigs) == 0 {
+		_, err := rest.InClusterConfig()
+		clusterConfigs = []g.Cluster{{Name: g.DefaultCluster, Config: config.Config, InCluster: err == nil}}
+	}
+
+	clusters := make(Clusters, 0)
+	for _, clusterConfig := range clusterConfigs {
+		if clusters.Get(clusterConfig.Name) != nil {
+			return nil, fmt.Errorf("cluster[%s] is duplicated", clusterConfig.Name)
+		}
+
+		cluster, err := NewCluster(clusterConfig)
+		if err != nil {
+			return nil, fmt.Errorf("cluster[%s]: %s", clusterConfig.Name, err)
+		}
+
+		clusters = append(clusters, cluster)
+	}
+
+	if len(clusters) == 0 {
+		return nil, errors.New("no cluster configured")
+	}
+
+	return clusters, nil
+}
+
+// NewCluster creates the clients of a cluster
+func NewCluster(config g.Cluster) (*Cluster, error) {
+	restConfig, err := buildConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("can not get kubernetes config: %v", err)
+	}
+
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("can not create watch client: %v", err)
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("can not create dynamic client: %v", err)
+	}
+
+	groupResources, err := restmapper.GetAPIGroupResources(client.Discovery())
+	if err != nil {
+		return nil, fmt.Errorf("can not discover api resources: %v", err)
+	}
+
+	return &Cluster{
+		Name:    config.Name,
+		Client:  client,
+		Dynamic: dynamicClient,
+		Mapper:  restmapper.NewDiscoveryRESTMapper(groupResources),
+	}, nil
+}
+
+// RESTMapping returns the kind and scope of a group/version/resource
+func (c *Cluster) RESTMapping(gvr schema.GroupVersionResource) (*meta.RESTMapping, error) {
+	gvk, err := c.Mapper.KindFor(gvr)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+}
+
+// Returns the in-cluster config when requested,
+// otherwise the config of the context in the kubeconfig file
+func buildConfig(config g.Cluster) (*rest.Config, error) {
+	if config.InCluster {
+		return rest.InClusterConfig()
+	}
+
+	kubeconfigPath := config.Config
+	if kubeconfigPath == "" {
+		kubeconfigPath = os.Getenv("HOME") + "/.kube/config"
+	}
+
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: kubeconfigPath},
+		&clientcmd.ConfigOverrides{CurrentContext: config.Context},
+	).ClientConfig()
+}
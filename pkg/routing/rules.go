@@ -0,0 +1,190 @@
+package routing
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/antonmedv/expr"
+	"github.com/antonmedv/expr/vm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/srelab/common/log"
+	"github.com/srelab/watcher/pkg/g"
+	"github.com/srelab/watcher/pkg/handlers/shared"
+)
+
+// prometheus collector
+var promeErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: strings.ToLower(g.NAME),
+	Subsystem: "rules",
+	Name:      "errors_total",
+	Help:      "Number of the evaluations of the rules that failed, e.g. a field missing in the object.",
+}, []string{"rule"})
+
+func init() {
+	prometheus.MustRegister(promeErrors)
+}
+
+// the variables known by the expressions, the values only matter to the type checks
+var environment = map[string]interface{}{
+	"event":     map[string]interface{}{},
+	"object":    map[string]interface{}{},
+	"oldObject": map[string]interface{}{},
+	"changed":   []interface{}{},
+}
+
+// Rule is a compiled routing rule
+type Rule struct {
+	Name       string   `json:"name"`
+	Expression string   `json:"expression"`
+	Handlers   []string `json:"handlers"`
+
+	program *vm.Program
+}
+
+// Compile compiles the expression of the rule, the unknown variables are rejected. The fields of
+// the objects are only known at runtime, so a non-boolean result is an error of the evaluation
+func Compile(config g.RuleConfig) (*Rule, error) {
+	program, err := expr.Compile(config.Expression, expr.Env(environment))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Rule{Name: config.Name, Expression: config.Expression, Handlers: config.Handlers, program: program}, nil
+}
+
+// Match evaluates the rule with the variables of Environment, accessing a field
+// of a missing parent (e.g. object.status.phase without status) is an error
+func (r *Rule) Match(env map[string]interface{}) (bool, error) {
+	output, err := expr.Run(r.program, env)
+	if err != nil {
+		return false, err
+	}
+
+	matched, ok := output.(bool)
+	if !ok {
+		return false, fmt.Errorf("rule[%s] returns %T instead of bool", r.Name, output)
+	}
+
+	return matched, nil
+}
+
+// Environment returns the variables of the expressions for the event,
+// the objects are given in their JSON form, e.g. object.metadata.labels.app.
+// event.Workload is the top-level owner, e.g. event.Workload.Kind == "CronJob", and event.Labels the
+// labels selected by Kubernetes.Owners. changed lists the paths changed by an update, e.g. "/spec/replicas" in changed
+func Environment(event *shared.Event) (map[string]interface{}, error) {
+	object, err := shared.ToUnstructured(event.Object)
+	if err != nil {
+		return nil, err
+	}
+
+	oldObject, err := shared.ToUnstructured(event.OldObject)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := make(map[string]interface{}, len(event.Labels))
+	for key, value := range event.Labels {
+		labels[key] = value
+	}
+
+	workload := event.Workload()
+	changed := make([]interface{}, 0, len(event.Diff))
+	for _, change := range event.Diff {
+		changed = append(changed, change.Path)
+	}
+
+	return map[string]interface{}{
+		"event": map[string]interface{}{
+			"Cluster":      event.Cluster,
+			"ResourceType": string(event.ResourceType),
+			"Action":       event.Action,
+			"Namespace":    event.Namespace,
+			"Key":          event.Key,
+			"Workload":     map[string]interface{}{"Kind": workload.Kind, "Name": workload.Name},
+			"Labels":       labels,
+		},
+		"object":    object,
+		"oldObject": oldObject,
+		"changed":   changed,
+	}, nil
+}
+
+// Engine routes the events to the handlers targeted by the matched rules
+type Engine struct {
+	rules   []*Rule
+	targets map[string]bool
+}
+
+// New compiles the rules, every rule needs a unique name and at least a handler
+func New(configs []g.RuleConfig) (*Engine, error) {
+	engine := &Engine{targets: make(map[string]bool)}
+
+	names := make(map[string]bool)
+	for _, config := range configs {
+		if config.Name == "" || names[config.Name] {
+			return nil, fmt.Errorf("rule name[%s] is empty or duplicated", config.Name)
+		}
+		names[config.Name] = true
+
+		if len(config.Handlers) == 0 {
+			return nil, fmt.Errorf("rule[%s] targets no handler", config.Name)
+		}
+
+		rule, err := Compile(config)
+		if err != nil {
+			return nil, fmt.Errorf("rule[%s]: %s", config.Name, err)
+		}
+
+		engine.rules = append(engine.rules, rule)
+		for _, handler := range config.Handlers {
+			engine.targets[handler] = true
+		}
+	}
+
+	return engine, nil
+}
+
+// Rules returns the compiled rules
+func (e *Engine) Rules() []*Rule {
+	return e.rules
+}
+
+// Targets returns true when the handler is targeted by any rule
+func (e *Engine) Targets(handler string) bool {
+	return e.targets[handler]
+}
+
+// Route returns the handlers targeted by the rules matching the event,
+// the rules failing to evaluate are counted and do not match
+func (e *Engine) Route(event *shared.Event) map[string]bool {
+	routes := make(map[string]bool)
+	if len(e.rules) == 0 {
+		return routes
+	}
+
+	env, err := Environment(event)
+	if err != nil {
+		log.Errorf("rules cannot evaluate %s: %s", event.Key, err)
+		return routes
+	}
+
+	for _, rule := range e.rules {
+		matched, err := rule.Match(env)
+		if err != nil {
+			promeErrors.WithLabelValues(rule.Name).Inc()
+			log.Debugf("rule[%s] evaluation of %s error: %s", rule.Name, event.Key, err)
+			continue
+		}
+
+		if !matched {
+			continue
+		}
+
+		for _, handler := range rule.Handlers {
+			routes[handler] = true
+		}
+	}
+
+	return routes
+}
@@ -0,0 +1,183 @@
+package routing
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/srelab/watcher/pkg/g"
+	"github.com/srelab/watcher/pkg/handlers/shared"
+
+	apiV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		configs []g.RuleConfig
+		wantErr string
+	}{
+		{name: "no rule", configs: nil},
+		{
+			name:    "valid",
+			configs: []g.RuleConfig{{Name: "prod", Expression: `event.Namespace == "prod"`, Handlers: []string{"etcd"}}},
+		},
+		{
+			name:    "empty name",
+			configs: []g.RuleConfig{{Expression: "true", Handlers: []string{"etcd"}}},
+			wantErr: "rule name[] is empty or duplicated",
+		},
+		{
+			name: "duplicated name",
+			configs: []g.RuleConfig{
+				{Name: "a", Expression: "true", Handlers: []string{"etcd"}},
+				{Name: "a", Expression: "false", Handlers: []string{"sa"}},
+			},
+			wantErr: "rule name[a] is empty or duplicated",
+		},
+		{
+			name:    "no handler",
+			configs: []g.RuleConfig{{Name: "a", Expression: "true"}},
+			wantErr: "rule[a] targets no handler",
+		},
+		{
+			name:    "syntax error",
+			configs: []g.RuleConfig{{Name: "a", Expression: `event.Namespace ==`, Handlers: []string{"etcd"}}},
+			wantErr: "rule[a]",
+		},
+		{
+			name:    "unknown variable",
+			configs: []g.RuleConfig{{Name: "a", Expression: `pod.metadata.name == "web"`, Handlers: []string{"etcd"}}},
+			wantErr: "rule[a]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.configs)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("New() error = %v", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("New() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRoute(t *testing.T) {
+	running := &apiV1.Pod{
+		ObjectMeta: metaV1.ObjectMeta{Name: "web", Namespace: "prod", Labels: map[string]string{"app": "web"}},
+		Status:     apiV1.PodStatus{Phase: apiV1.PodRunning},
+	}
+	pending := &apiV1.Pod{
+		ObjectMeta: metaV1.ObjectMeta{Name: "web", Namespace: "prod", Labels: map[string]string{"app": "web"}},
+		Status:     apiV1.PodStatus{Phase: apiV1.PodPending},
+	}
+
+	event := &shared.Event{
+		Cluster:      "east",
+		Action:       "update",
+		Namespace:    "prod",
+		Key:          "prod/web",
+		ResourceType: shared.ResourceTypePod,
+		Object:       running,
+		OldObject:    pending,
+		Diff:         []shared.Change{{Path: "/status/phase", Old: "Pending", New: "Running"}},
+		Owners:       []shared.Owner{{Kind: "ReplicaSet", Name: "web-1"}, {Kind: "Deployment", Name: "web"}},
+		Labels:       map[string]string{"team": "infra"},
+	}
+
+	tests := []struct {
+		name  string
+		rules []g.RuleConfig
+		want  map[string]bool
+	}{
+		{
+			name:  "no rule",
+			rules: nil,
+			want:  map[string]bool{},
+		},
+		{
+			name:  "event fields",
+			rules: []g.RuleConfig{{Name: "a", Expression: `event.Cluster == "east" && event.Action == "update" && event.ResourceType == "Pod"`, Handlers: []string{"etcd"}}},
+			want:  map[string]bool{"etcd": true},
+		},
+		{
+			name:  "workload and labels",
+			rules: []g.RuleConfig{{Name: "a", Expression: `event.Workload.Kind == "Deployment" && event.Labels.team == "infra"`, Handlers: []string{"sa"}}},
+			want:  map[string]bool{"sa": true},
+		},
+		{
+			name:  "object and old object",
+			rules: []g.RuleConfig{{Name: "a", Expression: `oldObject.status.phase == "Pending" && object.status.phase == "Running"`, Handlers: []string{"etcd"}}},
+			want:  map[string]bool{"etcd": true},
+		},
+		{
+			name:  "changed paths",
+			rules: []g.RuleConfig{{Name: "a", Expression: `"/status/phase" in changed`, Handlers: []string{"etcd"}}},
+			want:  map[string]bool{"etcd": true},
+		},
+		{
+			name:  "rule not matched",
+			rules: []g.RuleConfig{{Name: "a", Expression: `object.metadata.labels.app == "api"`, Handlers: []string{"etcd"}}},
+			want:  map[string]bool{},
+		},
+		{
+			name: "handlers of every matched rule",
+			rules: []g.RuleConfig{
+				{Name: "a", Expression: `event.Namespace == "prod"`, Handlers: []string{"etcd", "gateway"}},
+				{Name: "b", Expression: `event.Namespace == "dev"`, Handlers: []string{"sa"}},
+				{Name: "c", Expression: `true`, Handlers: []string{"gateway", "harbor"}},
+			},
+			want: map[string]bool{"etcd": true, "gateway": true, "harbor": true},
+		},
+		{
+			name: "rule reading a missing field does not match",
+			rules: []g.RuleConfig{
+				{Name: "a", Expression: `object.spec.missing.field == "x"`, Handlers: []string{"etcd"}},
+				{Name: "b", Expression: `true`, Handlers: []string{"sa"}},
+			},
+			want: map[string]bool{"sa": true},
+		},
+		{
+			name:  "non-boolean result does not match",
+			rules: []g.RuleConfig{{Name: "a", Expression: `event.Namespace`, Handlers: []string{"etcd"}}},
+			want:  map[string]bool{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine, err := New(tt.rules)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			if got := engine.Route(event); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Route() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchMissingField(t *testing.T) {
+	rule, err := Compile(g.RuleConfig{Name: "a", Expression: `object.status.phase == "Running"`, Handlers: []string{"etcd"}})
+	if err != nil {
+		t.Fatalf("Compile() error = %v", err)
+	}
+
+	env, err := Environment(&shared.Event{Object: map[string]interface{}{"metadata": map[string]interface{}{"name": "web"}}})
+	if err != nil {
+		t.Fatalf("Environment() error = %v", err)
+	}
+
+	if matched, err := rule.Match(env); matched || err == nil {
+		t.Errorf("Match() = %v, %v, want false and an error", matched, err)
+	}
+}